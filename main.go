@@ -1,9 +1,41 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nezia1/ssh-manager/pkg/cli"
 	"github.com/nezia1/ssh-manager/pkg/ui"
 )
 
 func main() {
+	args, err := cli.ParseGlobalFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if len(args) > 0 {
+		if err := cli.Run(args); err != nil {
+			// remote commands only print their own output, unless they could not be run
+			var exitErr cli.ExitStatusError
+			if errors.As(err, &exitErr) {
+				if exitErr.Err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				}
+				os.Exit(exitErr.Status)
+			}
+
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	ui.Start()
 }
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// runAdd stores a new connection to the given destination.
+func runAdd(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("add", flag.ContinueOnError)
+	connFlags := newConnectionFlags(flags)
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: ssh-manager add [flags] [user@]host[:port]")
+	}
+
+	conn, err := connection.ParseDestination(positional[0])
+	if err != nil {
+		return err
+	}
+
+	if err := connFlags.apply(flags, &conn); err != nil {
+		return err
+	}
+
+	password, err := connFlags.readPassword()
+	if err != nil {
+		return err
+	}
+
+	cm, err := connection.LoadConnections()
+	if err != nil {
+		return err
+	}
+
+	if err := cm.AddConnection(conn, password); err != nil {
+		return err
+	}
+
+	added := cm.Connections[len(cm.Connections)-1]
+	fmt.Fprintf(out, "added %s (%s)\n", added.Name(), added.ID)
+
+	return nil
+}
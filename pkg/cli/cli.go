@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+const usage = `Usage: ssh-manager [-config file | -profile name] [command]
+
+Without a command, the interactive interface is started.
+
+Connections are referenced by their ID, alias or user@host:port destination.
+
+Options:
+  -config file    store connections in the given file (default $SSH_MANAGER_CONFIG)
+  -profile name   use the connections of the given profile (default $SSH_MANAGER_PROFILE,
+                  or the one chosen with ssh-manager profile use)
+
+Commands:
+  add [flags] <destination>  add a connection to [user@]host[:port]
+  edit [flags] <connection>  change the given fields of a connection
+  rm <connection>...         delete connections, along with their stored password
+  list [-json|-format tmpl]  list connections, optionally selected with -tag, -group or -filter
+  show [-json] <connection>  show the details of a connection
+  connect <connection>       start an interactive session
+  exec [-t] [-n] <connection> [--] <command>...
+                             run a command, exiting with its exit status
+  exec [-tag t|-group g|-filter f|-all] [-parallel n] [-collate|-json] [--] <command>...
+                             run a command on several connections at the same time
+  import [path]              import hosts from an OpenSSH config file (default ~/.ssh/config)
+  export [-o file|-managed]  export connections as an OpenSSH config file
+  vault passwd               change the master passphrase of the vault
+  vault rekey                re-encrypt the vault with a new key
+  vault migrate [-remove]    move all passwords into the vault
+  profile [list]             list the profiles, marking the active one
+  profile use <name>         switch to the given profile, creating it if needed
+  help                       show this help
+
+Run ssh-manager <command> -h for the flags of a command.
+`
+
+// ParseGlobalFlags applies the flags given before the command, which select where the connections are stored.
+//
+// It returns the remaining arguments, starting with the command.
+func ParseGlobalFlags(args []string) ([]string, error) {
+	flags := flag.NewFlagSet("ssh-manager", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+	}
+	config := flags.String("config", "", "file to store the connections in")
+	profile := flags.String("profile", "", "profile to use the connections of")
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *config != "" && *profile != "" {
+		return nil, errors.New("-config and -profile cannot be used together")
+	}
+
+	if *config != "" {
+		connection.SetConfigPath(*config)
+	}
+
+	if *profile != "" {
+		if err := connection.SetProfile(*profile); err != nil {
+			return nil, err
+		}
+	}
+
+	return flags.Args(), nil
+}
+
+// Run runs the command given in args, which do not include the program name.
+func Run(args []string) error {
+	err := run(args, os.Stdout)
+
+	// the usage of the command has already been printed by the flag package
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+
+	return err
+}
+
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(out, usage)
+		return nil
+	}
+
+	switch args[0] {
+	case "add":
+		return runAdd(args[1:], out)
+	case "edit":
+		return runEdit(args[1:], out)
+	case "rm":
+		return runRm(args[1:], out)
+	case "list", "ls":
+		return runList(args[1:], out)
+	case "show":
+		return runShow(args[1:], out)
+	case "connect":
+		return runConnect(args[1:], out)
+	case "exec":
+		return runExec(args[1:], out)
+	case "import":
+		return runImport(args[1:], out)
+	case "export":
+		return runExport(args[1:], out)
+	case "vault":
+		return runVault(args[1:], out)
+	case "profile":
+		return runProfile(args[1:], out)
+	case "help", "-h", "--help":
+		fmt.Fprint(out, usage)
+		return nil
+	}
+
+	return fmt.Errorf("unknown command %q, see ssh-manager help", args[0])
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// runConnect starts an interactive session with the given connection, as when selecting it in the interactive interface.
+func runConnect(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("connect", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: ssh-manager connect <connection>")
+	}
+
+	cm, err := connection.LoadConnections()
+	if err != nil {
+		return err
+	}
+
+	_, conn, err := findConnection(cm, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	result, err := cm.StartSession(conn)
+	if err != nil {
+		return err
+	}
+
+	for _, stat := range result.Forwards {
+		fmt.Fprintln(out, stat)
+	}
+
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("remote shell exited with status %d", result.ExitStatus)
+	}
+
+	return nil
+}
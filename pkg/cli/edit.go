@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// runEdit changes the fields of a stored connection that are given as flags.
+func runEdit(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("edit", flag.ContinueOnError)
+	connFlags := newConnectionFlags(flags)
+	destination := flags.String("destination", "", "new destination of the connection, as [user@]host[:port]")
+	removePassword := flags.Bool("remove-password", false, "remove the stored password")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: ssh-manager edit [flags] <connection>")
+	}
+
+	cm, err := connection.LoadConnections()
+	if err != nil {
+		return err
+	}
+
+	index, conn, err := findConnection(cm, positional[0])
+	if err != nil {
+		return err
+	}
+
+	if *destination != "" {
+		parsed, err := connection.ParseDestination(*destination)
+		if err != nil {
+			return err
+		}
+
+		conn.Username, conn.Host, conn.Port = parsed.Username, parsed.Host, parsed.Port
+	}
+
+	if err := connFlags.apply(flags, &conn); err != nil {
+		return err
+	}
+
+	password, err := connFlags.readPassword()
+	if err != nil {
+		return err
+	}
+
+	if err := cm.EditConnection(index, conn, password, *removePassword); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "updated %s\n", cm.Connections[index].Name())
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// ExitStatusError is returned by commands that exit with the status of a remote command, like ssh does. Err is the error to report, if the command could not be run.
+type ExitStatusError struct {
+	Status int
+	Err    error
+}
+
+func (e ExitStatusError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+
+	return fmt.Sprintf("remote command exited with status %d", e.Status)
+}
+
+// execFailureStatus is the exit status when the remote command could not be run, as with ssh.
+const execFailureStatus = 255
+
+// runExec runs a command on a stored connection, passing its output, input and exit status through, so that the stored credentials can be used from scripts. When connections are selected with -tag, -group, -filter or -all instead, the command runs on all of them, see runExecAll.
+func runExec(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("exec", flag.ContinueOnError)
+	pty := flags.Bool("t", false, "allocate a pseudo-terminal, e.g. for interactive commands such as top")
+	noStdin := flags.Bool("n", false, "do not stream stdin to the command")
+	selector := newSelectorFlags(flags)
+	all := flags.Bool("all", false, "run the command on all connections")
+	fanOut := newFanOutFlags(flags)
+
+	// flags after the connection belong to the remote command, so they are not parsed with parseArgs
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	args = flags.Args()
+
+	// the command runs on several connections as soon as they are selected
+	if *all || *selector != (connection.Selector{}) {
+		if *pty {
+			return fmt.Errorf("-t cannot be used when running a command on several connections")
+		}
+
+		if len(args) > 0 && args[0] == "--" {
+			args = args[1:]
+		}
+
+		if len(args) == 0 {
+			return fmt.Errorf("usage: ssh-manager exec [-tag t|-group g|-filter f|-all] [-parallel n] [-collate|-json] [--] <command>...")
+		}
+
+		return runExecAll(*selector, strings.Join(args, " "), fanOut, out)
+	}
+
+	if len(args) > 1 && args[1] == "--" {
+		args = append(args[:1], args[2:]...)
+	}
+
+	if len(args) < 2 {
+		return fmt.Errorf("usage: ssh-manager exec [-t] [-n] <connection> [--] <command>...")
+	}
+
+	cm, err := connection.LoadConnections()
+	if err != nil {
+		return err
+	}
+
+	_, conn, err := findConnection(cm, args[0])
+	if err != nil {
+		return err
+	}
+
+	opts := connection.ExecOptions{
+		Command: strings.Join(args[1:], " "),
+		Pty:     *pty,
+		Stdout:  out,
+		Stderr:  os.Stderr,
+	}
+
+	if !*noStdin {
+		opts.Stdin = os.Stdin
+	}
+
+	result, err := cm.Exec(conn, opts)
+	if err != nil {
+		return ExitStatusError{Status: execFailureStatus, Err: err}
+	}
+
+	if result.ExitStatus != 0 {
+		return ExitStatusError{Status: result.ExitStatus}
+	}
+
+	return nil
+}
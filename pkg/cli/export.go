@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// runExport writes the stored connections as an OpenSSH config file, to stdout, to a file, or to the managed file in the config directory.
+func runExport(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", "", "write to the given file instead of stdout")
+	managed := flags.Bool("managed", false, "regenerate the managed file in the ssh-manager config directory, and print its path")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cm, err := connection.LoadConnections()
+	if err != nil {
+		return err
+	}
+
+	if *managed {
+		// the managed file is regenerated on every save
+		if err := cm.SaveToDisk(); err != nil {
+			return err
+		}
+
+		path, err := connection.ManagedSSHConfigPath()
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "Include %s\n", path)
+		return nil
+	}
+
+	if *output == "" {
+		return cm.ExportSSHConfig(out)
+	}
+
+	f, err := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, connection.StorageFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", *output, err)
+	}
+
+	if err := cm.ExportSSHConfig(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", *output, err)
+	}
+
+	return nil
+}
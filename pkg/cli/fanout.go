@@ -0,0 +1,292 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// fanOutFlags are the flags of the exec command that only apply when running a command on several connections.
+type fanOutFlags struct {
+	parallelism int
+	collate     bool
+	asJSON      bool
+}
+
+// newFanOutFlags registers the flags for running a command on several connections on the given flag set.
+func newFanOutFlags(flags *flag.FlagSet) *fanOutFlags {
+	ff := &fanOutFlags{}
+
+	flags.IntVar(&ff.parallelism, "parallel", connection.DefaultParallelism, "number of connections the command runs on at the same time")
+	flags.BoolVar(&ff.collate, "collate", false, "print the output of each connection at once when its command ends, instead of prefixing every line with its name")
+	flags.BoolVar(&ff.asJSON, "json", false, "print the results and output of every connection as a JSON array")
+
+	return ff
+}
+
+// execResultJSON is how the result of a command run on a connection is printed with -json.
+type execResultJSON struct {
+	ID          string
+	Name        string
+	Destination string
+	ExitStatus  int
+	// Seconds is the duration of the command
+	Seconds float64
+	Error   string `json:",omitempty"`
+	Stdout  string
+	Stderr  string
+}
+
+// runExecAll runs a command on the selected connections at the same time, printing their output as it comes with the name of the connection in front of every line, collated per connection, or as JSON, followed by a summary of the results.
+//
+// It exits with status 0 when the command succeeded on every connection, 1 when it exited with another status on any of them, and 255 when it could not be run on any of them.
+func runExecAll(selector connection.Selector, command string, ff *fanOutFlags, out io.Writer) error {
+	cm, err := connection.LoadConnections()
+	if err != nil {
+		return err
+	}
+
+	connections := cm.Select(selector)
+	if len(connections) == 0 {
+		return errors.New("no connection matches the selection")
+	}
+
+	width := 0
+	for _, conn := range connections {
+		width = max(width, len(conn.Name()))
+	}
+
+	var outputMu sync.Mutex
+	outputs := map[string]*execOutput{}
+
+	opts := connection.ExecAllOptions{
+		Command:     command,
+		Parallelism: ff.parallelism,
+		Output: func(conn connection.Connection) (io.Writer, io.Writer) {
+			output := &execOutput{}
+			if !ff.collate && !ff.asJSON {
+				prefix := fmt.Sprintf("%-*s | ", width, conn.Name())
+				output.stdout = &prefixWriter{mu: &outputMu, w: out, prefix: prefix}
+				output.stderr = &prefixWriter{mu: &outputMu, w: os.Stderr, prefix: prefix}
+			} else {
+				// stdout and stderr are collated together, as they would show on a terminal
+				combined := &lockedBuffer{}
+				output.combined = combined
+				output.stdout = &teeWriter{buffers: []*lockedBuffer{combined, &output.stdoutOnly}}
+				output.stderr = &teeWriter{buffers: []*lockedBuffer{combined, &output.stderrOnly}}
+			}
+
+			outputMu.Lock()
+			outputs[conn.ID] = output
+			outputMu.Unlock()
+
+			return output.stdout, output.stderr
+		},
+		Done: func(result connection.ExecResult) {
+			outputMu.Lock()
+			output := outputs[result.Conn.ID]
+			outputMu.Unlock()
+
+			if output == nil {
+				return
+			}
+
+			if pw, ok := output.stdout.(*prefixWriter); ok {
+				pw.Flush()
+				output.stderr.(*prefixWriter).Flush()
+			}
+
+			if ff.collate && !ff.asJSON {
+				fmt.Fprintf(out, "=== %s: %s ===\n", result.Conn.Name(), describeExecResult(result))
+				out.Write(output.combined.Bytes())
+				if n := output.combined.Len(); n > 0 && output.combined.Bytes()[n-1] != '\n' {
+					fmt.Fprintln(out)
+				}
+			}
+		},
+	}
+
+	results := cm.ExecAll(connections, opts)
+
+	if ff.asJSON {
+		var encoded []execResultJSON
+		for _, result := range results {
+			entry := execResultJSON{
+				ID:          result.Conn.ID,
+				Name:        result.Conn.Name(),
+				Destination: result.Conn.Destination(),
+				ExitStatus:  result.ExitStatus,
+				Seconds:     result.Duration.Seconds(),
+			}
+
+			if result.Err != nil {
+				entry.Error = result.Err.Error()
+			}
+
+			if output := outputs[result.Conn.ID]; output != nil {
+				entry.Stdout = output.stdoutOnly.String()
+				entry.Stderr = output.stderrOnly.String()
+			}
+
+			encoded = append(encoded, entry)
+		}
+
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(encoded); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintln(out)
+		if err := printExecSummary(results, out); err != nil {
+			return err
+		}
+	}
+
+	status := 0
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			status = execFailureStatus
+		case result.ExitStatus != 0 && status == 0:
+			status = 1
+		}
+	}
+
+	if status != 0 {
+		return ExitStatusError{Status: status}
+	}
+
+	return nil
+}
+
+// printExecSummary prints a table of the exit status and duration of the command on every connection, with the reason it failed on the ones it could not run on.
+func printExecSummary(results []connection.ExecResult, out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDESTINATION\tEXIT\tDURATION\tERROR")
+	for _, result := range results {
+		status, reason := strconv.Itoa(result.ExitStatus), ""
+		if result.Err != nil {
+			status, reason = "-", result.Err.Error()
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.Conn.Name(), result.Conn.Destination(), status, result.Duration.Round(time.Millisecond), reason)
+	}
+
+	return w.Flush()
+}
+
+// describeExecResult describes how the command ended on a connection, e.g. "exited with status 0 after 1.2s".
+func describeExecResult(result connection.ExecResult) string {
+	if result.Err != nil {
+		return fmt.Sprintf("failed after %s: %v", result.Duration.Round(time.Millisecond), result.Err)
+	}
+
+	return fmt.Sprintf("exited with status %d after %s", result.ExitStatus, result.Duration.Round(time.Millisecond))
+}
+
+// execOutput holds where the output of the command run on a connection goes.
+type execOutput struct {
+	stdout io.Writer
+	stderr io.Writer
+	// combined, stdoutOnly and stderrOnly are only written when the output is collated
+	combined   *lockedBuffer
+	stdoutOnly lockedBuffer
+	stderrOnly lockedBuffer
+}
+
+// prefixWriter writes every line with a prefix, such as the name of the connection it comes from, so that the lines of several connections can be interleaved. Lines are written whole, incomplete lines being kept until they are complete or flushed.
+type prefixWriter struct {
+	// mu is shared by all the writers writing to w
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.buf = append(pw.buf, p...)
+
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+
+		pw.writeLine(pw.buf[:i+1])
+		pw.buf = pw.buf[i+1:]
+	}
+}
+
+// Flush writes the last line if it was not terminated by a newline.
+func (pw *prefixWriter) Flush() {
+	if len(pw.buf) > 0 {
+		pw.writeLine(append(pw.buf, '\n'))
+		pw.buf = nil
+	}
+}
+
+func (pw *prefixWriter) writeLine(line []byte) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	io.WriteString(pw.w, pw.prefix)
+	pw.w.Write(line)
+}
+
+// lockedBuffer is a bytes.Buffer that can be written from several goroutines, as stdout and stderr are copied concurrently.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(p []byte) (int, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	return lb.buf.Write(p)
+}
+
+func (lb *lockedBuffer) Bytes() []byte {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	return lb.buf.Bytes()
+}
+
+func (lb *lockedBuffer) Len() int {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	return lb.buf.Len()
+}
+
+func (lb *lockedBuffer) String() string {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	return lb.buf.String()
+}
+
+// teeWriter writes to all of its buffers.
+type teeWriter struct {
+	buffers []*lockedBuffer
+}
+
+func (tw *teeWriter) Write(p []byte) (int, error) {
+	for _, buffer := range tw.buffers {
+		buffer.Write(p)
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// stringList is a flag that can be given multiple times, collecting every value.
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(value string) error {
+	*sl = append(*sl, value)
+	return nil
+}
+
+// connectionFlags are the flags describing a connection, shared by the add and edit commands.
+type connectionFlags struct {
+	alias          string
+	group          string
+	tags           stringList
+	identityFiles  stringList
+	identitiesOnly bool
+	keySource      string
+	jumpHosts      stringList
+	forwards       stringList
+	aliveInterval  int
+	aliveCountMax  int
+	reconnect      bool
+	forwardAgent   bool
+	password       bool
+	passwordStdin  bool
+}
+
+// newConnectionFlags registers the connection flags on the given flag set.
+func newConnectionFlags(flags *flag.FlagSet) *connectionFlags {
+	cf := &connectionFlags{}
+
+	flags.StringVar(&cf.alias, "alias", "", "friendly name of the connection, e.g. prod-db-1")
+	flags.StringVar(&cf.group, "group", "", "group of the connection, with subgroups separated by slashes, e.g. prod/db")
+	flags.Var(&cf.tags, "tag", "tag of the connection, can be repeated")
+	flags.Var(&cf.identityFiles, "identity", "identity file to authenticate with, can be repeated")
+	flags.BoolVar(&cf.identitiesOnly, "identities-only", false, "only use the given identity files, not the default ones")
+	flags.StringVar(&cf.keySource, "keys", "", "where keys are taken from: both, agent or files")
+	flags.Var(&cf.jumpHosts, "jump", "jump host to go through, as an alias or user@host:port, can be repeated")
+	flags.Var(&cf.forwards, "forward", `port forward, e.g. "L 5432:db:5432" or "D 1080", can be repeated`)
+	flags.IntVar(&cf.aliveInterval, "server-alive-interval", 0, fmt.Sprintf("seconds between keepalives sent during sessions, -1 to disable them (default %d)", connection.DefaultServerAliveInterval))
+	flags.IntVar(&cf.aliveCountMax, "server-alive-count-max", 0, fmt.Sprintf("unanswered keepalives after which the connection is lost (default %d)", connection.DefaultServerAliveCountMax))
+	flags.BoolVar(&cf.forwardAgent, "forward-agent", false, "make the local ssh-agent available on the host during sessions")
+	flags.BoolVar(&cf.reconnect, "reconnect", false, "start sessions again when their connection is lost")
+	flags.BoolVar(&cf.password, "password", false, "prompt for a password to store")
+	flags.BoolVar(&cf.passwordStdin, "password-stdin", false, "read the password to store from stdin")
+
+	return cf
+}
+
+// apply sets the fields of the connection for the flags that were given on the command line, leaving the other ones untouched.
+func (cf *connectionFlags) apply(flags *flag.FlagSet, conn *connection.Connection) error {
+	var err error
+
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "alias":
+			conn.Alias = cf.alias
+		case "group":
+			conn.Group = cf.group
+		case "tag":
+			conn.Tags = cf.tags
+		case "identity":
+			conn.IdentityFiles = cf.identityFiles
+		case "identities-only":
+			conn.IdentitiesOnly = cf.identitiesOnly
+		case "keys":
+			switch source := connection.KeySource(cf.keySource); source {
+			case connection.KeySourceBoth, connection.KeySourceAgent, connection.KeySourceFiles:
+				conn.KeySource = source
+			default:
+				err = fmt.Errorf("invalid key source %q, expected both, agent or files", cf.keySource)
+			}
+		case "jump":
+			conn.JumpHosts = cf.jumpHosts
+		case "server-alive-interval":
+			conn.ServerAliveInterval = cf.aliveInterval
+		case "server-alive-count-max":
+			conn.ServerAliveCountMax = cf.aliveCountMax
+		case "reconnect":
+			conn.Reconnect = cf.reconnect
+		case "forward-agent":
+			conn.ForwardAgent = cf.forwardAgent
+		case "forward":
+			conn.Forwards = nil
+			for _, spec := range cf.forwards {
+				forward, forwardErr := connection.ParseForward(spec)
+				if forwardErr != nil {
+					err = forwardErr
+					return
+				}
+				conn.Forwards = append(conn.Forwards, forward)
+			}
+		}
+	})
+
+	return err
+}
+
+// newSelectorFlags registers the flags selecting connections on the given flag set.
+func newSelectorFlags(flags *flag.FlagSet) *connection.Selector {
+	selector := &connection.Selector{}
+
+	flags.StringVar(&selector.Tag, "tag", "", "only select the connections with this tag")
+	flags.StringVar(&selector.Group, "group", "", "only select the connections in this group or its subgroups")
+	flags.StringVar(&selector.Filter, "filter", "", "only select the connections whose ID, alias, host or destination matches this glob pattern, e.g. 'web-*'")
+
+	return selector
+}
+
+// readPassword returns the password to store, read from stdin or the terminal depending on the flags, or nil if none was asked for.
+func (cf *connectionFlags) readPassword() (*string, error) {
+	switch {
+	case cf.passwordStdin:
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return nil, fmt.Errorf("failed to read password from stdin: %v", err)
+		}
+
+		password := strings.TrimRight(line, "\r\n")
+		return &password, nil
+	case cf.password:
+		password, err := connection.PromptPassphrase("Password:")
+		if err != nil {
+			return nil, err
+		}
+
+		return &password, nil
+	}
+
+	return nil, nil
+}
+
+// parseArgs parses the flags of a command, which can be given before or after its positional arguments.
+//
+// It returns the positional arguments.
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// findConnection returns the index of the stored connection referenced by its ID, alias or destination, and the connection itself.
+func findConnection(cm connection.ConnectionManager, reference string) (int, connection.Connection, error) {
+	conn, ok := cm.Find(reference)
+	if !ok {
+		return -1, connection.Connection{}, fmt.Errorf("no connection matches %q", reference)
+	}
+
+	return cm.Index(conn.ID), conn, nil
+}
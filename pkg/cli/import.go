@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// runImport imports the hosts of an OpenSSH config file into the stored connections.
+func runImport(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	path, err := connection.DefaultSSHConfigPath()
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+
+	cm, err := connection.LoadConnections()
+	if err != nil {
+		return err
+	}
+
+	result, err := cm.ImportSSHConfig(path)
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(out, "warning: %s\n", warning)
+	}
+
+	for _, conn := range result.Added {
+		fmt.Fprintf(out, "added %s\n", conn.Destination())
+	}
+
+	for _, conn := range result.Duplicates {
+		fmt.Fprintf(out, "skipped %s (already stored)\n", conn.Destination())
+	}
+
+	fmt.Fprintf(out, "imported %d connections from %s\n", len(result.Added), path)
+
+	return nil
+}
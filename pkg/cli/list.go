@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// runList prints the stored connections as a table, as JSON, or formatted with a template, optionally keeping only the ones selected by tag, group or name.
+func runList(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the connections as a JSON array")
+	format := flags.String("format", "", "print each connection with a Go template, e.g. '{{.Alias}} {{.Destination}}'")
+	selector := newSelectorFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cm, err := connection.LoadConnections()
+	if err != nil {
+		return err
+	}
+
+	connections := cm.Select(*selector)
+
+	switch {
+	case *asJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(connections)
+	case *format != "":
+		tmpl, err := template.New("format").Parse(*format)
+		if err != nil {
+			return fmt.Errorf("invalid format: %v", err)
+		}
+
+		for _, conn := range connections {
+			if err := tmpl.Execute(out, conn); err != nil {
+				return fmt.Errorf("failed to format %s: %v", conn.Name(), err)
+			}
+			fmt.Fprintln(out)
+		}
+
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tDESTINATION\tGROUP\tTAGS")
+	for _, conn := range connections {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", conn.ID, conn.Alias, conn.Destination(), conn.Group, strings.Join(conn.Tags, ","))
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// runProfile lists the profiles, each holding its own connections, and switches between them.
+func runProfile(args []string, out io.Writer) error {
+	if !connection.UsesProfiles() {
+		return errors.New("profiles cannot be used along with -config or SSH_MANAGER_CONFIG")
+	}
+
+	if len(args) == 0 || args[0] == "list" {
+		return listProfiles(out)
+	}
+
+	switch args[0] {
+	case "use":
+		if len(args) != 2 {
+			return errors.New("usage: ssh-manager profile use <name>")
+		}
+
+		if err := connection.UseProfile(args[1]); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "now using profile %s\n", args[1])
+		return nil
+	}
+
+	return fmt.Errorf("unknown profile command %q, expected list or use", args[0])
+}
+
+// listProfiles prints the existing profiles, marking the active one with an asterisk.
+func listProfiles(out io.Writer) error {
+	profiles, err := connection.Profiles()
+	if err != nil {
+		return err
+	}
+
+	active, err := connection.ActiveProfile()
+	if err != nil {
+		return err
+	}
+
+	for _, profile := range profiles {
+		marker := " "
+		if profile == active {
+			marker = "*"
+		}
+		fmt.Fprintf(out, "%s %s\n", marker, profile)
+	}
+
+	return nil
+}
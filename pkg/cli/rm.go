@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// runRm deletes the given connections, along with their stored password.
+func runRm(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("rm", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("usage: ssh-manager rm <connection>...")
+	}
+
+	cm, err := connection.LoadConnections()
+	if err != nil {
+		return err
+	}
+
+	// every reference is resolved before deleting anything, so that a missing one leaves the connections untouched
+	var ids []string
+	var names []string
+	for _, reference := range flags.Args() {
+		_, conn, err := findConnection(cm, reference)
+		if err != nil {
+			return err
+		}
+
+		if !slices.Contains(ids, conn.ID) {
+			ids = append(ids, conn.ID)
+			names = append(names, conn.Name())
+		}
+	}
+
+	if err := cm.DeleteConnections(ids); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		fmt.Fprintf(out, "removed %s\n", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// runShow prints the details of a stored connection.
+func runShow(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("show", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the connection as JSON")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: ssh-manager show [-json] <connection>")
+	}
+
+	cm, err := connection.LoadConnections()
+	if err != nil {
+		return err
+	}
+
+	_, conn, err := findConnection(cm, positional[0])
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(conn)
+	}
+
+	var forwards []string
+	for _, forward := range conn.Forwards {
+		forwards = append(forwards, forward.String())
+	}
+
+	keySource := conn.KeySource
+	if keySource == "" {
+		keySource = connection.KeySourceBoth
+	}
+
+	password := "none"
+	if conn.IsPassword {
+		password = "stored with " + cm.SecretBackend(conn)
+	}
+
+	keepalive := "disabled"
+	if interval := conn.KeepaliveInterval(); interval > 0 {
+		keepalive = fmt.Sprintf("every %s, lost after %d unanswered", interval, conn.KeepaliveCountMax())
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%s\n", conn.ID)
+	fmt.Fprintf(w, "Alias:\t%s\n", conn.Alias)
+	fmt.Fprintf(w, "Destination:\t%s\n", conn.Destination())
+	fmt.Fprintf(w, "Group:\t%s\n", conn.Group)
+	fmt.Fprintf(w, "Tags:\t%s\n", strings.Join(conn.Tags, ", "))
+	fmt.Fprintf(w, "Password:\t%s\n", password)
+	fmt.Fprintf(w, "Keys:\t%s\n", keySource)
+	fmt.Fprintf(w, "Identity files:\t%s\n", strings.Join(conn.IdentityFiles, ", "))
+	fmt.Fprintf(w, "Identities only:\t%t\n", conn.IdentitiesOnly)
+	fmt.Fprintf(w, "Jump hosts:\t%s\n", strings.Join(cm.JumpHostNames(conn), " → "))
+	fmt.Fprintf(w, "Forwards:\t%s\n", strings.Join(forwards, ", "))
+	fmt.Fprintf(w, "Keepalives:\t%s\n", keepalive)
+	fmt.Fprintf(w, "Reconnect:\t%t\n", conn.Reconnect)
+	fmt.Fprintf(w, "Forward agent:\t%t\n", conn.ForwardAgent)
+
+	return w.Flush()
+}
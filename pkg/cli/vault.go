@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// runVault manages the built-in vault: changing its master passphrase, re-keying it, and migrating passwords into it.
+func runVault(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing vault command: passwd, rekey or migrate")
+	}
+
+	vault, err := connection.OpenVault()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "passwd":
+		if err := unlockVault(vault); err != nil {
+			return err
+		}
+
+		passphrase, err := promptNewPassphrase()
+		if err != nil {
+			return err
+		}
+
+		if err := vault.ChangePassphrase(passphrase); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, "vault passphrase changed")
+		return nil
+	case "rekey":
+		passphrase, err := vaultPassphrase()
+		if err != nil {
+			return err
+		}
+
+		if err := vault.Rekey(passphrase); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, "vault re-encrypted with a new key")
+		return nil
+	case "migrate":
+		return runVaultMigrate(vault, args[1:], out)
+	}
+
+	return fmt.Errorf("unknown vault command %q, expected passwd, rekey or migrate", args[0])
+}
+
+// runVaultMigrate moves the passwords of all connections into the vault, and makes it the default secret backend.
+func runVaultMigrate(vault *connection.Vault, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("vault migrate", flag.ContinueOnError)
+	remove := flags.Bool("remove", false, "remove the passwords from their previous store once migrated")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cm, err := connection.LoadConnections()
+	if err != nil {
+		return err
+	}
+
+	// a new vault needs its passphrase to be confirmed
+	if !vault.Exists() {
+		passphrase, err := promptNewPassphrase()
+		if err != nil {
+			return err
+		}
+
+		if err := vault.Unlock(passphrase); err != nil {
+			return err
+		}
+	}
+
+	migrated, err := cm.MigrateSecrets(connection.SecretBackendVault, *remove)
+	for _, conn := range migrated {
+		fmt.Fprintf(out, "migrated %s\n", conn.Destination())
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "migrated %d passwords, the vault is now the default secret backend\n", len(migrated))
+	return nil
+}
+
+// unlockVault unlocks the vault with the passphrase from the environment or the terminal.
+func unlockVault(vault *connection.Vault) error {
+	passphrase, err := vaultPassphrase()
+	if err != nil {
+		return err
+	}
+
+	return vault.Unlock(passphrase)
+}
+
+func vaultPassphrase() (string, error) {
+	if passphrase, ok := os.LookupEnv(connection.VaultPassphraseEnv); ok {
+		return passphrase, nil
+	}
+
+	return connection.PromptPassphrase("Enter vault passphrase:")
+}
+
+// promptNewPassphrase prompts for a new passphrase twice, to make sure it was not mistyped.
+func promptNewPassphrase() (string, error) {
+	passphrase, err := connection.PromptPassphrase("Enter new vault passphrase:")
+	if err != nil {
+		return "", err
+	}
+
+	confirmation, err := connection.PromptPassphrase("Confirm new vault passphrase:")
+	if err != nil {
+		return "", err
+	}
+
+	if passphrase != confirmation {
+		return "", fmt.Errorf("passphrases do not match")
+	}
+
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase cannot be empty")
+	}
+
+	return passphrase, nil
+}
@@ -0,0 +1,85 @@
+package connection
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// KeySource selects where the keys used to authenticate a connection come from.
+type KeySource string
+
+const (
+	KeySourceBoth  KeySource = "both"
+	KeySourceAgent KeySource = "agent"
+	KeySourceFiles KeySource = "files"
+)
+
+// usesAgent reports whether keys should be requested from the ssh-agent. An empty key source uses both the agent and key files.
+func (ks KeySource) usesAgent() bool {
+	return ks != KeySourceFiles
+}
+
+// usesFiles reports whether keys should be read from disk.
+func (ks KeySource) usesFiles() bool {
+	return ks != KeySourceAgent
+}
+
+// dialAgent connects to the ssh-agent listening on SSH_AUTH_SOCK. If no agent is running, it returns a nil agent and connection without error.
+func dialAgent() (agent.ExtendedAgent, net.Conn, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil, nil
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to connect to ssh-agent: %v", err)
+	}
+
+	return agent.NewClient(conn), conn, nil
+}
+
+// forwardAgent makes the ssh-agent listening on SSH_AUTH_SOCK available on the remote side of the session, so that it can authenticate to further hosts with the local keys.
+func forwardAgent(client *ssh.Client, session *ssh.Session) error {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return errors.New("agent forwarding is enabled, but SSH_AUTH_SOCK is not set")
+	}
+
+	if err := agent.ForwardToRemote(client, socket); err != nil {
+		return fmt.Errorf("unable to forward ssh-agent: %v", err)
+	}
+
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		return fmt.Errorf("request for agent forwarding failed: %v", err)
+	}
+
+	return nil
+}
+
+// agentHasKey reports whether the public key matching the private key at keyPath is loaded in the agent, by looking at the .pub file next to it.
+func agentHasKey(agentSigners []ssh.Signer, keyPath string) bool {
+	b, err := os.ReadFile(keyPath + ".pub")
+	if err != nil {
+		return false
+	}
+
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return false
+	}
+
+	for _, signer := range agentSigners {
+		if bytes.Equal(signer.PublicKey().Marshal(), publicKey.Marshal()) {
+			return true
+		}
+	}
+
+	return false
+}
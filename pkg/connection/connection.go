@@ -1,28 +1,147 @@
 package connection
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/x/term"
+	"github.com/muesli/cancelreader"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"os"
 	"os/signal"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 const (
 	DefaultPort = 22
 )
 
+// DefaultIdentityFiles are the keys in ~/.ssh that are tried for every connection, unless IdentitiesOnly is set.
+var DefaultIdentityFiles = []string{"id_rsa", "id_dsa", "id_ecdsa", "id_ed25519"}
+
 type Connection struct {
-	Username   string
-	Host       string
-	Port       int
-	IsPassword bool
+	// ID identifies the connection, it is generated when the connection is stored and never changes
+	ID string
+	// Alias is a friendly name for the connection, such as prod-db-1
+	Alias          string
+	Username       string
+	Host           string
+	Port           int
+	IsPassword     bool
+	IdentityFiles  []string
+	IdentitiesOnly bool
+	KeySource      KeySource
+	// Group is the path of the group the connection belongs to, with subgroups separated by slashes, e.g. prod/db
+	Group string
+	Tags  []string
+	// JumpHosts are the IDs of the connections to go through, in order
+	JumpHosts []string
+	Forwards  []Forward
+	// ServerAliveInterval is the number of seconds between keepalives sent to the server during sessions. DefaultServerAliveInterval is used if it is 0, and keepalives are disabled if it is negative.
+	ServerAliveInterval int
+	// ServerAliveCountMax is the number of keepalives in a row the server can leave unanswered before the connection is considered lost, DefaultServerAliveCountMax if 0
+	ServerAliveCountMax int
+	// Reconnect starts the session again when the connection is lost
+	Reconnect bool
+	// ForwardAgent makes the local ssh-agent available on the host during sessions. Anyone with root access to the host can use the keys of the agent while the session is open.
+	ForwardAgent bool
+	// SecretBackend overrides the secret backend of the manager for this connection
+	SecretBackend string
+	// LegacySecretKey is the key the password was stored under before secrets were keyed by ID. It is cleared once the password is stored again.
+	LegacySecretKey string
+}
+
+// newConnectionID generates a random ID for a new connection.
+func newConnectionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate connection id: %v", err))
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// Name returns the alias of the connection, or its destination if it has none.
+func (c Connection) Name() string {
+	if c.Alias != "" {
+		return c.Alias
+	}
+
+	return c.Destination()
+}
+
+// Destination returns the connection in the user@host:port format.
+func (c Connection) Destination() string {
+	return fmt.Sprintf("%s@%s", c.Username, c.Address())
+}
+
+// ParseDestination parses a destination in the [user@]host[:port] format into a connection, validating each part. IPv6 addresses need to be enclosed in square brackets to be given a port, e.g. [::1]:22. The current user and the default port are used when they are not given.
+func ParseDestination(destination string) (Connection, error) {
+	conn := Connection{Port: DefaultPort}
+	hostPort := strings.TrimSpace(destination)
+
+	if username, rest, ok := strings.Cut(hostPort, "@"); ok {
+		conn.Username = username
+		hostPort = rest
+	} else if u, err := user.Current(); err == nil {
+		conn.Username = u.Username
+	}
+
+	if err := ValidateUsername(conn.Username); err != nil {
+		return Connection{}, err
+	}
+
+	port := ""
+	switch {
+	case strings.HasPrefix(hostPort, "["):
+		host, rest, ok := strings.Cut(hostPort[1:], "]")
+		if !ok {
+			return Connection{}, fmt.Errorf("missing closing bracket in %s", hostPort)
+		}
+
+		conn.Host = host
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return Connection{}, fmt.Errorf("unexpected %q after IPv6 address, expected :port", rest)
+			}
+			port = rest[1:]
+		}
+	case strings.Count(hostPort, ":") == 1:
+		conn.Host, port, _ = strings.Cut(hostPort, ":")
+	default:
+		// several colons without brackets are an IPv6 address without a port
+		conn.Host = hostPort
+	}
+
+	if err := ValidateHost(conn.Host); err != nil {
+		return Connection{}, err
+	}
+
+	if port != "" {
+		var err error
+		conn.Port, err = strconv.Atoi(port)
+		if err != nil {
+			return Connection{}, fmt.Errorf("invalid port %q", port)
+		}
+
+		if err := ValidatePort(conn.Port); err != nil {
+			return Connection{}, err
+		}
+	}
+
+	return conn, nil
 }
 
-func (c Connection) SSHCommand() (string, []string, error) {
+// SSHCommand returns the command and arguments starting a session with the connection using the ssh binary, or sshpass for password connections. Jump hosts, forwards, the key source and keepalives are passed as options, so that the session matches the one of the built-in client.
+func (cm ConnectionManager) SSHCommand(c Connection) (string, []string, error) {
 	var command string
 	var args []string
 
@@ -30,13 +149,50 @@ func (c Connection) SSHCommand() (string, []string, error) {
 		command = "ssh"
 	} else {
 		command = "sshpass"
-		password, err := c.Password()
+		password, err := cm.Password(c)
 		if err != nil {
 			return "", nil, fmt.Errorf("unable to get password for ssh connection %v: %v", c.Host, err)
 		}
 		args = append(args, "-p", password, "ssh")
 	}
 
+	for _, identityFile := range c.IdentityFiles {
+		args = append(args, "-i", identityFile)
+	}
+
+	if c.IdentitiesOnly {
+		args = append(args, "-o", "IdentitiesOnly=yes")
+	}
+
+	if !c.KeySource.usesAgent() {
+		args = append(args, "-o", "IdentityAgent=none")
+	}
+
+	if c.ForwardAgent {
+		args = append(args, "-A")
+	}
+
+	jumps, err := cm.JumpChain(c)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(jumps) > 0 {
+		var destinations []string
+		for _, jump := range jumps {
+			destinations = append(destinations, jump.Destination())
+		}
+		args = append(args, "-J", strings.Join(destinations, ","))
+	}
+
+	for _, forward := range c.Forwards {
+		flag, spec, _ := strings.Cut(forward.String(), " ")
+		args = append(args, "-"+flag, spec)
+	}
+
+	args = append(args, "-o", fmt.Sprintf("ServerAliveInterval=%d", int(c.KeepaliveInterval().Seconds())))
+	args = append(args, "-o", fmt.Sprintf("ServerAliveCountMax=%d", c.KeepaliveCountMax()))
+
 	args = append(args, fmt.Sprintf("%s@%s", c.Username, c.Host))
 	args = append(args, "-p", fmt.Sprintf("%d", c.Port))
 
@@ -45,11 +201,22 @@ func (c Connection) SSHCommand() (string, []string, error) {
 
 type Item struct {
 	Conn Connection
+	// Via describes the jump hosts the connection goes through, if any
+	Via string
+	// Depth is the number of groups the item is nested in
+	Depth int
+	// Reachability is the result of the last check of the connection, if any
+	Reachability Reachability
 }
 
 func (i Item) Title() string {
+	if i.Conn.Alias != "" {
+		return indent(i.Depth) + i.Conn.Alias
+	}
+
 	var title strings.Builder
 
+	title.WriteString(indent(i.Depth))
 	fmt.Fprintf(&title, "%s@%s", i.Conn.Username, i.Conn.Host)
 	if i.Conn.Port == 0 {
 		fmt.Fprintf(&title, ":%d", i.Conn.Port)
@@ -62,139 +229,510 @@ func (i Item) Description() string {
 	var description string
 	if i.Conn.IsPassword {
 		description = "Password connection"
+	} else if i.Conn.KeySource == KeySourceAgent {
+		description = "SSH agent connection"
 	} else {
 		description = "SSH key connection"
 	}
 
-	return description
+	// the title does not show where the connection goes when it has an alias
+	if i.Conn.Alias != "" {
+		description = i.Conn.Destination() + " · " + description
+	}
+
+	if i.Via != "" {
+		description += " via " + i.Via
+	}
+
+	// the host can use the keys of the agent while connected, which should not go unnoticed
+	if i.Conn.ForwardAgent {
+		description += " · ⚠ agent forwarded"
+	}
+
+	for _, forward := range i.Conn.Forwards {
+		description += " · " + forward.String()
+	}
+
+	for _, tag := range i.Conn.Tags {
+		description += " #" + tag
+	}
+
+	if reachability := i.Reachability.String(); reachability != "" {
+		description += " · " + reachability
+	}
+
+	return indent(i.Depth) + description
 }
 
 func (i Item) FilterValue() string {
-	return i.Conn.Host
+	value := strings.TrimSpace(i.Conn.Alias + " " + i.Conn.Host)
+	for _, tag := range i.Conn.Tags {
+		value += " #" + tag
+	}
+
+	return value
 }
 
 type ConnectionManager struct {
-	Connections []Connection
+	// Version is the schema version of the storage file, see SchemaVersion
+	Version int
+	Secrets SecretSettings
+	Checks  CheckSettings
+	// CollapsedGroups are the paths of the groups that are collapsed in the tree view
+	CollapsedGroups []string
+	Connections     []Connection
+
+	// loaded is the content of the storage file when the connections were last loaded or saved, used to detect changes made by other instances of ssh-manager
+	loaded []byte
+	// pendingSecretChanges are the changes to the secret store of connection changes that could not be saved because of changes made by other instances, see saveWithSecrets
+	pendingSecretChanges []func(cm *ConnectionManager) error
 }
 
-func (cm *ConnectionManager) AddConnection(host string, user string, port int, password *string) error {
-	if port == 0 {
-		port = DefaultPort
+func (cm *ConnectionManager) AddConnection(connection Connection, password *string) error {
+	if connection.Port == 0 {
+		connection.Port = DefaultPort
+	}
+
+	connection.ID = newConnectionID()
+	connection.LegacySecretKey = ""
+
+	if err := connection.Validate(); err != nil {
+		return err
 	}
 
-	connection := Connection{
-		Username: user,
-		Host:     host,
-		Port:     port,
+	if err := cm.ValidateAlias(connection); err != nil {
+		return err
 	}
 
+	connection.JumpHosts = cm.jumpHostIDs(connection.JumpHosts)
+	connection.Group = normalizeGroup(connection.Group)
+	connection.Tags = normalizeTags(connection.Tags)
+
+	var change func(*ConnectionManager) error
 	if password != nil {
-		err := connection.StorePassword(*password)
+		connection.IsPassword = true
 
-		if err != nil {
-			return fmt.Errorf("failed to store password after adding new connection: %v", err)
+		added, password := connection, *password
+		change = func(cm *ConnectionManager) error {
+			if err := cm.StorePassword(added, password); err != nil {
+				return fmt.Errorf("failed to store password after adding new connection: %v", err)
+			}
+
+			return nil
 		}
+	}
 
-		connection.IsPassword = true
+	return cm.saveWithSecrets(change, func() {
+		cm.Connections = append(cm.Connections, connection)
+	})
+}
+
+func (cm *ConnectionManager) DeleteConnection(index int) error {
+	return cm.DeleteConnections([]string{cm.Connections[index].ID})
+}
+
+// DeleteConnections deletes the connections with the given IDs at once, along with their stored passwords when no remaining connection reads them, and saves to disk once.
+func (cm *ConnectionManager) DeleteConnections(ids []string) error {
+	deleted := func(conn Connection) bool {
+		return slices.Contains(ids, conn.ID)
+	}
+
+	// delete passwords from the secret store if applicable, along with the connections, each shared one only once
+	var passwords []Connection
+	for _, conn := range cm.Connections {
+		if !deleted(conn) || !conn.IsPassword {
+			continue
+		}
+
+		shared := slices.ContainsFunc(cm.Connections, func(other Connection) bool {
+			return !deleted(other) && other.IsPassword && cm.sameSecret(conn, other)
+		})
+
+		removed := slices.ContainsFunc(passwords, func(other Connection) bool {
+			return cm.sameSecret(conn, other)
+		})
+
+		if !shared && !removed {
+			passwords = append(passwords, conn)
+		}
 	}
 
-	cm.Connections = append(cm.Connections, connection)
+	var change func(*ConnectionManager) error
+	if len(passwords) > 0 {
+		change = func(cm *ConnectionManager) error {
+			for _, conn := range passwords {
+				if err := cm.RemovePassword(conn); err != nil {
+					return fmt.Errorf("failed to remove password when deleting connection %s: %v", conn.Name(), err)
+				}
+			}
 
-	err := cm.SaveToDisk()
+			return nil
+		}
+	}
 
+	return cm.saveWithSecrets(change, func() {
+		cm.Connections = slices.DeleteFunc(cm.Connections, deleted)
+	})
+}
+
+// EditConnection replaces the connection at index, keeping its ID. If password is not nil, it replaces the stored password, and if removePassword is set, the stored password is deleted.
+//
+// An existing password is moved when the connection switches to another secret backend, or when it was still stored under its legacy key.
+func (cm *ConnectionManager) EditConnection(index int, connection Connection, password *string, removePassword bool) error {
+	if connection.Port == 0 {
+		connection.Port = DefaultPort
+	}
+
+	old := cm.Connections[index]
+	connection.ID = old.ID
+	connection.IsPassword = old.IsPassword
+	connection.LegacySecretKey = ""
+
+	if err := connection.Validate(); err != nil {
+		return err
+	}
+
+	if err := cm.ValidateAlias(connection); err != nil {
+		return err
+	}
+
+	connection.JumpHosts = cm.jumpHostIDs(connection.JumpHosts)
+	connection.Group = normalizeGroup(connection.Group)
+	connection.Tags = normalizeTags(connection.Tags)
+
+	var change func(*ConnectionManager) error
+	switch {
+	case removePassword:
+		if old.IsPassword && !cm.secretShared(old) {
+			change = func(cm *ConnectionManager) error {
+				if err := cm.RemovePassword(old); err != nil {
+					return fmt.Errorf("failed to remove password when editing connection: %v", err)
+				}
+
+				return nil
+			}
+		}
+		connection.IsPassword = false
+	case password != nil:
+		removeOld := old.IsPassword && old.secretKey() != connection.secretKey() && !cm.secretShared(old)
+		connection.IsPassword = true
+
+		edited, password := connection, *password
+		change = func(cm *ConnectionManager) error {
+			if removeOld {
+				if err := cm.RemovePassword(old); err != nil {
+					return fmt.Errorf("failed to remove previous password when editing connection: %v", err)
+				}
+			}
+
+			if err := cm.StorePassword(edited, password); err != nil {
+				return fmt.Errorf("failed to store password when editing connection: %v", err)
+			}
+
+			return nil
+		}
+	case old.IsPassword && (old.secretKey() != connection.secretKey() || cm.SecretBackend(old) != cm.SecretBackend(connection)):
+		edited := connection
+		change = func(cm *ConnectionManager) error {
+			if err := cm.movePassword(old, edited); err != nil {
+				return fmt.Errorf("failed to move password when editing connection: %v", err)
+			}
+
+			return nil
+		}
+	}
+
+	return cm.saveWithSecrets(change, func() {
+		cm.Connections[index] = connection
+	})
+}
+
+// movePassword moves the stored password of a connection from where it is stored for old to where it is stored for new.
+func (cm ConnectionManager) movePassword(old Connection, new Connection) error {
+	password, err := cm.Password(old)
 	if err != nil {
-		return fmt.Errorf("failed to save to disk after adding new connection: %v", err)
+		return err
 	}
 
-	return nil
+	if err := cm.StorePassword(new, password); err != nil {
+		return err
+	}
+
+	if cm.secretShared(old) {
+		return nil
+	}
+
+	return cm.RemovePassword(old)
 }
 
-func (cm *ConnectionManager) DeleteConnection(index int) error {
-	// delete password from pass if applicable
-	connectionToDelete := cm.Connections[index]
-	if connectionToDelete.IsPassword {
-		err := connectionToDelete.RemovePassword()
-		if err != nil {
-			return fmt.Errorf("failed to remove password when deleting connection: %v", err)
+// secretShared reports whether another connection reads its password from the same place as c. This happens with connections that were stored before secrets were keyed by ID, when they had the same user and host.
+func (cm ConnectionManager) secretShared(c Connection) bool {
+	for _, conn := range cm.Connections {
+		if conn.ID != c.ID && conn.IsPassword && cm.sameSecret(conn, c) {
+			return true
 		}
 	}
 
-	// delete connection
-	cm.Connections = append(cm.Connections[:index], cm.Connections[index+1:]...)
+	return false
+}
 
-	err := cm.SaveToDisk()
+// sameSecret reports whether both connections read their password from the same place.
+func (cm ConnectionManager) sameSecret(a Connection, b Connection) bool {
+	return a.secretKey() == b.secretKey() && cm.SecretBackend(a) == cm.SecretBackend(b)
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to save to disk: %v", err)
+// ValidateAlias checks that the alias of the connection can be used to reference it: it can only contain letters, digits, dots, underscores and dashes, as it is also used as a Host pattern in the managed ssh_config, and has to be unique.
+func (cm ConnectionManager) ValidateAlias(c Connection) error {
+	if c.Alias == "" {
+		return nil
+	}
+
+	for i, r := range c.Alias {
+		if !isAlphanumeric(r) && r != '.' && r != '_' && r != '-' {
+			return fmt.Errorf("invalid character %q in alias %q at position %d, only letters, digits, '.', '_' and '-' are allowed", r, c.Alias, i+1)
+		}
+	}
+
+	for _, conn := range cm.Connections {
+		if conn.ID != c.ID && conn.Alias == c.Alias {
+			return fmt.Errorf("alias %q is already used by %s", c.Alias, conn.Destination())
+		}
 	}
+
 	return nil
 }
 
-func (cm ConnectionManager) Items() []list.Item {
-	items := []list.Item{}
-	for _, conn := range cm.Connections {
-		items = append(items, Item{Conn: conn})
+// uniqueAlias returns alias, with a number appended to it if another connection already uses it.
+func (cm ConnectionManager) uniqueAlias(alias string) string {
+	candidate := alias
+	for i := 2; ; i++ {
+		if _, ok := cm.findByAlias(candidate); !ok {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d", alias, i)
 	}
-	return items
 }
 
-// StartSession starts a new SSH session with the given connection. It will prompt for a password if one was provided, otherwise, it will try to find all available keys in the default paths.
-// TODO: allow custom key paths
-func (c Connection) StartSession() error {
-	var authMethods []ssh.AuthMethod
+// jumpHostIDs replaces the jump host references that match a stored connection by its ID, so that they keep pointing to it when it is edited. Unknown references are kept as they are, and reported when connecting.
+func (cm ConnectionManager) jumpHostIDs(references []string) []string {
+	var ids []string
+	for _, reference := range references {
+		if jump, ok := cm.Find(reference); ok {
+			reference = jump.ID
+		}
+		ids = append(ids, reference)
+	}
 
-	if c.IsPassword {
-		password, err := c.Password()
+	return ids
+}
+
+// JumpHostNames returns the names of the jump hosts of a connection, as shown to the user.
+func (cm ConnectionManager) JumpHostNames(c Connection) []string {
+	var names []string
+	for _, reference := range c.JumpHosts {
+		if jump, ok := cm.Find(reference); ok {
+			reference = jump.Name()
+		}
+		names = append(names, reference)
+	}
 
+	return names
+}
+
+// item returns the list item of a connection, shown at the given depth of the tree.
+func (cm ConnectionManager) item(conn Connection, depth int) Item {
+	item := Item{Conn: conn, Depth: depth}
+
+	if len(conn.JumpHosts) > 0 {
+		jumps, err := cm.JumpChain(conn)
 		if err != nil {
-			return err
+			item.Via = fmt.Sprintf("invalid jump hosts (%v)", err)
+		} else {
+			var hops []string
+			for _, jump := range jumps {
+				hops = append(hops, jump.Name())
+			}
+			item.Via = strings.Join(hops, " → ")
 		}
+	}
 
-		authMethods = append(authMethods, ssh.Password(password))
+	return item
+}
+
+// Find returns the stored connection referenced by its ID, its alias, or its destination (user@host:port), in that order.
+func (cm ConnectionManager) Find(reference string) (Connection, bool) {
+	for _, conn := range cm.Connections {
+		if conn.ID != "" && conn.ID == reference {
+			return conn, true
+		}
 	}
 
-	// try to find all available keys in the default paths
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return fmt.Errorf("unable to get user home directory: %v", err)
+	if conn, ok := cm.findByAlias(reference); ok {
+		return conn, true
+	}
+
+	return cm.findByDestination(reference)
+}
+
+// findByDestination returns the stored connection with the given destination (user@host:port).
+func (cm ConnectionManager) findByDestination(destination string) (Connection, bool) {
+	for _, conn := range cm.Connections {
+		if conn.Destination() == destination {
+			return conn, true
+		}
 	}
 
-	keyPaths := []string{
-		fmt.Sprintf("%s/.ssh/id_rsa", homeDir),
-		fmt.Sprintf("%s/.ssh/id_dsa", homeDir),
-		fmt.Sprintf("%s/.ssh/id_ecdsa", homeDir),
-		fmt.Sprintf("%s/.ssh/id_ed25519", homeDir),
+	return Connection{}, false
+}
+
+// findByAlias returns the stored connection with the given alias.
+func (cm ConnectionManager) findByAlias(alias string) (Connection, bool) {
+	for _, conn := range cm.Connections {
+		if conn.Alias != "" && conn.Alias == alias {
+			return conn, true
+		}
 	}
 
-	for _, keyPath := range keyPaths {
-		newAuthMethod, err := publicKeyFile(keyPath)
+	return Connection{}, false
+}
+
+// Index returns the index of the stored connection with the given ID, or -1 if there is none.
+func (cm ConnectionManager) Index(id string) int {
+	return slices.IndexFunc(cm.Connections, func(conn Connection) bool {
+		return conn.ID == id
+	})
+}
+
+// JumpChain resolves the jump hosts of a connection into the stored connections to dial through, in order. Jump hosts that have jump hosts of their own are expanded first, as with OpenSSH's ProxyJump.
+func (cm ConnectionManager) JumpChain(c Connection) ([]Connection, error) {
+	return cm.jumpChain(c, map[string]bool{c.ID: true})
+}
+
+func (cm ConnectionManager) jumpChain(c Connection, visited map[string]bool) ([]Connection, error) {
+	var chain []Connection
+
+	for _, reference := range c.JumpHosts {
+		jump, ok := cm.Find(reference)
+		if !ok {
+			return nil, fmt.Errorf("jump host %s is not a stored connection", reference)
+		}
+
+		if visited[jump.ID] {
+			return nil, fmt.Errorf("jump host %s is used more than once in the chain of %s", jump.Name(), c.Name())
+		}
+
+		visited[jump.ID] = true
+		jumpChain, err := cm.jumpChain(jump, visited)
 		if err != nil {
-			continue
+			return nil, err
 		}
 
-		authMethods = append(authMethods, newAuthMethod)
+		chain = append(chain, jumpChain...)
+		chain = append(chain, jump)
 	}
 
-	config := &ssh.ClientConfig{
-		User:            c.Username,
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // TODO: add host key verification
+	return chain, nil
+}
+
+// SessionResult describes how an interactive session ended.
+type SessionResult struct {
+	Conn Connection
+	// ExitStatus is the exit status of the remote shell
+	ExitStatus int
+	Duration   time.Duration
+	// Reconnects is the number of times the session was started again after the connection was lost
+	Reconnects int
+	// Forwards holds the traffic that went through each forward of the connection
+	Forwards []*ForwardStat
+}
+
+// String describes how the session ended, e.g. "prod-db-1: exited with status 0 after 3m12s".
+func (sr SessionResult) String() string {
+	description := fmt.Sprintf("%s: exited with status %d after %s", sr.Conn.Name(), sr.ExitStatus, sr.Duration.Round(time.Second))
+	switch {
+	case sr.Reconnects == 1:
+		description += ", reconnected once"
+	case sr.Reconnects > 1:
+		description += fmt.Sprintf(", reconnected %d times", sr.Reconnects)
 	}
 
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", c.Host, c.Port), config)
+	return description
+}
+
+// StartSession starts a new SSH session with the given connection, going through its jump hosts first. Each hop authenticates with its own credentials, as described in Dial.
+//
+// Keepalives are sent to the server as configured on the connection, and if Reconnect is set, a session whose connection is lost is started again, waiting longer after every failed attempt and reporting what happened on the terminal.
+//
+// It returns once the remote shell exits. A non-zero exit status is not an error, it is reported in the returned result along with the duration of the session.
+func (cm ConnectionManager) StartSession(c Connection) (SessionResult, error) {
+	result := SessionResult{Conn: c, Forwards: c.forwardStats()}
+	start := time.Now()
+
+	connectedOnce := false
+	failures := 0
+	for {
+		connected, err := cm.runSession(c, &result, connectedOnce)
+		result.Duration = time.Since(start)
 
+		if err == nil {
+			return result, nil
+		}
+
+		// sessions that could never be started, or that ended for another reason than a lost connection, are not started again, but failures to reconnect are retried
+		if !c.Reconnect || (!connectedOnce && !connected) || (connected && !errors.Is(err, ErrConnectionLost)) {
+			return result, err
+		}
+
+		connectedOnce = true
+		if connected {
+			failures = 0
+		}
+
+		if failures == MaxReconnectAttempts {
+			return result, fmt.Errorf("gave up reconnecting after %d attempts: %w", failures, err)
+		}
+
+		delay := reconnectDelay(failures)
+		failures++
+		fmt.Fprintf(os.Stderr, "\r\nssh-manager: %s: %v, reconnecting in %s (attempt %d of %d)\r\n", c.Name(), err, delay, failures, MaxReconnectAttempts)
+		time.Sleep(delay)
+	}
+}
+
+// runSession dials the connection, starts its forwards, and runs the remote shell until it exits or the connection is lost. The exit status of the shell and the traffic of the forwards are recorded in result, and reconnecting reports that the session has been started again once the shell is running.
+//
+// It returns whether the shell could be started, and ErrConnectionLost if the connection dropped or the server stopped answering keepalives.
+func (cm ConnectionManager) runSession(c Connection, result *SessionResult, reconnecting bool) (bool, error) {
+	client, err := cm.Dial(c)
+	if err != nil {
+		return false, err
+	}
+
+	defer client.Close()
+
+	listeners, err := startForwards(client, result.Forwards)
 	if err != nil {
-		return fmt.Errorf("unable to start ssh connection: %v", err)
+		return false, err
+	}
+
+	for _, listener := range listeners {
+		defer listener.Close()
 	}
 
 	session, err := client.NewSession()
 
 	if err != nil {
-		return fmt.Errorf("unable to start ssh session: %v", err)
+		return false, fmt.Errorf("unable to start ssh session: %v", err)
 	}
 
 	defer session.Close()
 
+	if c.ForwardAgent {
+		if err := forwardAgent(client, session); err != nil {
+			return false, err
+		}
+	}
+
 	modes := ssh.TerminalModes{
 		ssh.ECHO:          1,     // enable echoing (needed in raw mode, otherwise typed characters are invisible since sent directly to the ssh session)
 		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
@@ -207,52 +745,93 @@ func (c Connection) StartSession() error {
 	oldState, err := term.MakeRaw(fd)
 
 	if err != nil {
-		return fmt.Errorf("failed to set terminal into raw mode: %v", err)
+		return false, fmt.Errorf("failed to set terminal into raw mode: %v", err)
 	}
 
 	defer term.Restore(fd, oldState)
 
-	// handles resize asynchronously
-	go handleResize(session)
+	// handles resize and keepalives asynchronously, until the session is over
+	done := make(chan struct{})
+	defer close(done)
+	go handleResize(session, done)
+
+	lost := make(chan struct{})
+	if interval := c.KeepaliveInterval(); interval > 0 {
+		go keepAlive(client, interval, c.KeepaliveCountMax(), done, lost)
+	}
 
 	w, h, err := term.GetSize(fd)
 
 	if err != nil {
-		return fmt.Errorf("cannot get terminal size: %v", err)
+		return false, fmt.Errorf("cannot get terminal size: %v", err)
 	}
 
 	// request a pseudo-terminal
 	if err := session.RequestPty("xterm-256color", h, w, modes); err != nil {
-		return fmt.Errorf("request for pseudo terminal failed: %v", err)
+		return false, fmt.Errorf("request for pseudo terminal failed: %v", err)
+	}
+
+	// the session keeps reading stdin in the background, which would swallow the next key press once it is over if it could not be cancelled
+	stdin, err := cancelreader.NewReader(os.Stdin)
+	if err != nil {
+		return false, fmt.Errorf("failed to read from stdin: %v", err)
 	}
 
+	defer stdin.Close()
+	defer stdin.Cancel()
+
 	session.Stdout = os.Stdout
-	session.Stdin = os.Stdin
+	session.Stdin = stdin
 	session.Stderr = os.Stderr
 
 	// start remote shell
 	if err := session.Shell(); err != nil {
-		return fmt.Errorf("failed to start shell: %v", err)
+		return false, fmt.Errorf("failed to start shell: %v", err)
+	}
+
+	if reconnecting {
+		result.Reconnects++
+		fmt.Fprintf(os.Stderr, "ssh-manager: reconnected to %s\r\n", c.Name())
 	}
 
 	// wait for remote shell to close
 	err = session.Wait()
+
+	select {
+	case <-lost:
+		return true, fmt.Errorf("%w: no reply to %d keepalives", ErrConnectionLost, c.KeepaliveCountMax())
+	default:
+	}
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitStatus = exitErr.ExitStatus()
+		return true, nil
+	}
+
+	// the shell can only end without an exit status when the connection drops
 	if err != nil {
-		return fmt.Errorf("remote shell exited with error: %v", err)
+		return true, fmt.Errorf("%w: remote shell exited with error: %v", ErrConnectionLost, err)
 	}
 
-	return nil
+	return true, nil
 }
 
-// handleResize creates a channel and listens to it for SIGWINCH. It handles resizing the ssh session, as we need to explicitely inform it when our terminal window size changes.
+// handleResize creates a channel and listens to it for SIGWINCH. It handles resizing the ssh session, as we need to explicitely inform it when our terminal window size changes, until done is closed.
 //
 // Meant to be used as a goroutine.
-func handleResize(session *ssh.Session) {
+func handleResize(session *ssh.Session, done <-chan struct{}) {
 	signals := make(chan os.Signal, 1)
 
 	signal.Notify(signals, syscall.SIGWINCH)
+	defer signal.Stop(signals)
+
 	for {
-		<-signals
+		select {
+		case <-done:
+			return
+		case <-signals:
+		}
 
 		fd := os.Stdin.Fd()
 		cols, rows, err := term.GetSize(fd)
@@ -267,40 +846,140 @@ func handleResize(session *ssh.Session) {
 	}
 }
 
-func publicKeyFile(file string) (ssh.AuthMethod, error) {
-	key, err := os.ReadFile(file)
+// authMethods returns the authentication methods for the connection: the password from secrets if there is one, followed by the keys from sshAgent (which may be nil if no agent is running) and the ones returned by KeyPaths. Passphrases of keys are only prompted for if interactive is set.
+func (c Connection) authMethods(sshAgent agent.ExtendedAgent, secrets SecretStore, interactive bool) ([]ssh.AuthMethod, error) {
+	var authMethods []ssh.AuthMethod
+
+	if c.IsPassword {
+		password, err := secrets.Get(c.secretKey())
+
+		if err != nil {
+			return nil, err
+		}
+
+		authMethods = append(authMethods, ssh.Password(password))
+	}
+
+	if c.KeySource == KeySourceAgent && sshAgent == nil {
+		return nil, fmt.Errorf("connection %s@%s only uses the ssh-agent, but SSH_AUTH_SOCK is not set", c.Username, c.Host)
+	}
+
+	// all keys need to be part of the same auth method, as the client only tries each method once. Keys are only loaded once the server asks for them, so that passphrases are not prompted for needlessly
+	authMethods = append(authMethods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		var signers []ssh.Signer
+
+		if sshAgent != nil {
+			agentSigners, err := sshAgent.Signers()
+			if err != nil {
+				return nil, fmt.Errorf("unable to get keys from ssh-agent: %v", err)
+			}
 
+			signers = append(signers, agentSigners...)
+		}
+
+		if !c.KeySource.usesFiles() {
+			return signers, nil
+		}
+
+		keyPaths, err := c.KeyPaths()
+		if err != nil {
+			return nil, err
+		}
+
+		agentSigners := signers
+		for i, keyPath := range keyPaths {
+			// no need to ask for a passphrase if the agent already offers this key
+			if agentHasKey(agentSigners, keyPath) {
+				continue
+			}
+
+			signer, err := signerFromFile(keyPath, interactive)
+			if err != nil {
+				// identity files were explicitly configured, so they should not be skipped silently
+				if i < len(c.IdentityFiles) {
+					return nil, fmt.Errorf("unable to load identity file %s: %v", keyPath, err)
+				}
+				continue
+			}
+
+			signers = append(signers, signer)
+		}
+
+		return signers, nil
+	}))
+
+	return authMethods, nil
+}
+
+// KeyPaths returns the private keys to try for the connection, in order: its identity files, followed by the default keys unless IdentitiesOnly is set.
+func (c Connection) KeyPaths() ([]string, error) {
+	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("unable to get user home directory: %v", err)
 	}
 
-	signer, err := ssh.ParsePrivateKey(key)
+	var keyPaths []string
+	for _, identityFile := range c.IdentityFiles {
+		keyPaths = append(keyPaths, expandHome(identityFile, homeDir))
+	}
 
-	if err == nil {
-		return ssh.PublicKeys(signer), nil
+	if c.IdentitiesOnly {
+		return keyPaths, nil
 	}
 
-	fmt.Printf("Enter passphrase for key %s:", file)
-	passphrase, err := readPassphrase()
+	for _, defaultKey := range DefaultIdentityFiles {
+		keyPath := filepath.Join(homeDir, ".ssh", defaultKey)
+		if !slices.Contains(keyPaths, keyPath) {
+			keyPaths = append(keyPaths, keyPath)
+		}
+	}
+
+	return keyPaths, nil
+}
+
+// expandHome replaces a leading ~ in path with the user home directory.
+func expandHome(path string, homeDir string) string {
+	if path == "~" {
+		return homeDir
+	}
+
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(homeDir, path[2:])
+	}
+
+	return path
+}
+
+func signerFromFile(file string, interactive bool) (ssh.Signer, error) {
+	key, err := os.ReadFile(file)
 
 	if err != nil {
 		return nil, err
 	}
 
-	signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	signer, err := ssh.ParsePrivateKey(key)
+
+	if err == nil || !interactive {
+		return signer, err
+	}
+
+	passphrase, err := PromptPassphrase(fmt.Sprintf("Enter passphrase for key %s:", file))
 
 	if err != nil {
 		return nil, err
 	}
 
-	return ssh.PublicKeys(signer), nil
+	return ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
 }
 
-func readPassphrase() (string, error) {
-	password, err := term.ReadPassword(os.Stdin.Fd())
+// openTerminal opens the controlling terminal to prompt the user, as OpenSSH does, so that prompts neither consume stdin nor end up in stdout when they are redirected, e.g. when piping into ssh-manager exec. Without a controlling terminal, stdin and stdout are used instead.
+//
+// It returns the files to read the answer from and write the prompt to, and a function closing them.
+func openTerminal() (*os.File, *os.File, func()) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
 	if err != nil {
-		return "", err
+		return os.Stdin, os.Stdout, func() {}
 	}
 
-	return string(password), nil
+	return tty, tty, func() { tty.Close() }
 }
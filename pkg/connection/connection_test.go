@@ -0,0 +1,67 @@
+package connection
+
+import "testing"
+
+func TestValidateAlias(t *testing.T) {
+	cm := ConnectionManager{Connections: []Connection{
+		{ID: "1", Alias: "prod-db-1", Username: "root", Host: "db1", Port: 22},
+		{ID: "2", Username: "root", Host: "db2", Port: 22},
+	}}
+
+	tests := []struct {
+		name    string
+		conn    Connection
+		wantErr bool
+	}{
+		{
+			name: "no alias",
+			conn: Connection{ID: "3"},
+		},
+		{
+			name: "letters, digits, dots, underscores and dashes",
+			conn: Connection{ID: "3", Alias: "Web_1.prod-eu"},
+		},
+		{
+			name: "alias kept by the same connection",
+			conn: Connection{ID: "1", Alias: "prod-db-1"},
+		},
+		{
+			name:    "alias of another connection",
+			conn:    Connection{ID: "3", Alias: "prod-db-1"},
+			wantErr: true,
+		},
+		{
+			name:    "non-ASCII letter",
+			conn:    Connection{ID: "3", Alias: "café"},
+			wantErr: true,
+		},
+		{
+			name:    "space",
+			conn:    Connection{ID: "3", Alias: "prod db"},
+			wantErr: true,
+		},
+		{
+			name:    "ssh_config pattern",
+			conn:    Connection{ID: "3", Alias: "prod-*"},
+			wantErr: true,
+		},
+		{
+			name:    "destination separators",
+			conn:    Connection{ID: "3", Alias: "root@db:22"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := cm.ValidateAlias(tt.conn)
+			if tt.wantErr && err == nil {
+				t.Fatalf("ValidateAlias(%q) returned no error", tt.conn.Alias)
+			}
+
+			if !tt.wantErr && err != nil {
+				t.Fatalf("ValidateAlias(%q) returned an error: %v", tt.conn.Alias, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package connection
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Dial opens an authenticated SSH client to the connection. Its jump hosts are dialed first, in order, each hop being tunneled through the previous one, and authenticated with its own credentials.
+//
+// Closing the returned client also closes the clients of the jump hosts.
+func (cm ConnectionManager) Dial(c Connection) (*ssh.Client, error) {
+	return cm.dial(c, true)
+}
+
+// dial opens an authenticated SSH client to the connection, as described in Dial. When interactive is not set, nothing is prompted for on the terminal: unknown host keys are refused, keys protected by a passphrase are skipped, and a locked vault is reported with ErrVaultLocked. Otherwise the vault passphrase is prompted for on the terminal, whatever VaultPassphrasePrompt is, so that frontends can dial while they have released the terminal without changing it.
+func (cm ConnectionManager) dial(c Connection, interactive bool) (*ssh.Client, error) {
+	jumps, err := cm.JumpChain(c)
+	if err != nil {
+		return nil, err
+	}
+
+	hops := append(jumps, c)
+
+	var sshAgent agent.ExtendedAgent
+	for _, hop := range hops {
+		if !hop.KeySource.usesAgent() {
+			continue
+		}
+
+		var agentConn net.Conn
+
+		sshAgent, agentConn, err = dialAgent()
+		if err != nil {
+			return nil, err
+		}
+
+		// the agent is only needed during authentication
+		if agentConn != nil {
+			defer agentConn.Close()
+		}
+		break
+	}
+
+	var prompt func() (string, error)
+	if interactive {
+		prompt = PromptVaultPassphrase
+	}
+
+	var client *ssh.Client
+	for _, hop := range hops {
+		secrets, err := newSecretStore(cm.SecretBackend(hop), cm.Secrets, prompt)
+		if err != nil {
+			return nil, err
+		}
+
+		hopClient, err := hop.dialThrough(client, sshAgent, secrets, interactive)
+		if err != nil {
+			if client != nil {
+				client.Close()
+			}
+			return nil, err
+		}
+
+		// the previous hop is only needed for as long as the tunnel through it is
+		if client != nil {
+			go closeWith(client, hopClient)
+		}
+
+		client = hopClient
+	}
+
+	return client, nil
+}
+
+// dialThrough opens an authenticated SSH client to the connection, tunneled through the given client, or directly if it is nil.
+func (c Connection) dialThrough(through *ssh.Client, sshAgent agent.ExtendedAgent, secrets SecretStore, interactive bool) (*ssh.Client, error) {
+	config, err := c.clientConfig(sshAgent, secrets, interactive)
+	if err != nil {
+		return nil, err
+	}
+
+	if through == nil {
+		client, err := ssh.Dial("tcp", c.Address(), config)
+		if err != nil {
+			return nil, fmt.Errorf("unable to start ssh connection to %s: %v", c.Destination(), err)
+		}
+
+		return client, nil
+	}
+
+	conn, err := through.Dial("tcp", c.Address())
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach %s through jump host: %v", c.Destination(), err)
+	}
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, c.Address(), config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to start ssh connection to %s: %v", c.Destination(), err)
+	}
+
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
+
+// clientConfig returns the configuration used to authenticate the connection, using the keys from sshAgent if it is not nil, and the password from secrets. Unknown host keys and key passphrases are only prompted for if interactive is set.
+func (c Connection) clientConfig(sshAgent agent.ExtendedAgent, secrets SecretStore, interactive bool) (*ssh.ClientConfig, error) {
+	if !c.KeySource.usesAgent() {
+		sshAgent = nil
+	}
+
+	authMethods, err := c.authMethods(sshAgent, secrets, interactive)
+	if err != nil {
+		return nil, err
+	}
+
+	// unknown hosts should have been confirmed in the TUI already, this is a fallback for jump hosts, or in case the key was removed in between
+	prompt := HostKeyPrompt(terminalHostKeyPrompt)
+	if !interactive {
+		prompt = nil
+	}
+
+	hostKeyCallback, err := hostKeyCallback(prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:              c.Username,
+		Auth:              authMethods,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: knownHostKeyAlgorithms(c.Address()),
+	}, nil
+}
+
+// closeWith closes client once other has been closed.
+func closeWith(client *ssh.Client, other *ssh.Client) {
+	other.Wait()
+	client.Close()
+}
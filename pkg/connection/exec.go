@@ -0,0 +1,157 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+	"golang.org/x/crypto/ssh"
+)
+
+// ExecOptions describes a command to run with Exec.
+type ExecOptions struct {
+	// Command is the command line run by the remote shell
+	Command string
+	// Pty allocates a pseudo-terminal for the command, putting the local terminal in raw mode if Stdin is one
+	Pty bool
+	// Stdin is streamed to the command, which gets no input if it is nil
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Exec runs a command on the connection instead of an interactive shell, going through its jump hosts first, as described in Dial. Keepalives are sent as for interactive sessions, but a command whose connection is lost is not started again, as it may not be safe to run twice. Forwards are not started either.
+//
+// Stdin keeps being read in the background until it returns EOF or an error, even once the command has exited.
+//
+// It returns once the command exits. A non-zero exit status is not an error, it is reported in the returned result along with the duration of the command.
+func (cm ConnectionManager) Exec(c Connection, opts ExecOptions) (SessionResult, error) {
+	return cm.exec(c, opts, true)
+}
+
+// exec runs a command on the connection, as described in Exec. Nothing is prompted for on the terminal when dialing if interactive is not set, see dial.
+func (cm ConnectionManager) exec(c Connection, opts ExecOptions, interactive bool) (SessionResult, error) {
+	result := SessionResult{Conn: c}
+	start := time.Now()
+
+	client, err := cm.dial(c, interactive)
+	if err != nil {
+		return result, err
+	}
+
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return result, fmt.Errorf("unable to start ssh session: %v", err)
+	}
+
+	defer session.Close()
+
+	if c.ForwardAgent {
+		if err := forwardAgent(client, session); err != nil {
+			return result, err
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	lost := make(chan struct{})
+	if interval := c.KeepaliveInterval(); interval > 0 {
+		go keepAlive(client, interval, c.KeepaliveCountMax(), done, lost)
+	}
+
+	if opts.Pty {
+		restore, err := requestExecPty(session, opts.Stdin, done)
+		if err != nil {
+			return result, err
+		}
+
+		defer restore()
+	}
+
+	session.Stdout = opts.Stdout
+	session.Stderr = opts.Stderr
+
+	// stdin is copied without the session waiting for it, otherwise the command could not end before stdin does
+	if opts.Stdin != nil {
+		stdinPipe, err := session.StdinPipe()
+		if err != nil {
+			return result, fmt.Errorf("failed to stream stdin: %v", err)
+		}
+
+		go func() {
+			io.Copy(stdinPipe, opts.Stdin)
+			stdinPipe.Close()
+		}()
+	}
+
+	if err := session.Start(opts.Command); err != nil {
+		return result, fmt.Errorf("failed to start command: %v", err)
+	}
+
+	err = session.Wait()
+	result.Duration = time.Since(start)
+
+	select {
+	case <-lost:
+		return result, fmt.Errorf("%w: no reply to %d keepalives", ErrConnectionLost, c.KeepaliveCountMax())
+	default:
+	}
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitStatus = exitErr.ExitStatus()
+		return result, nil
+	}
+
+	if err != nil {
+		return result, fmt.Errorf("%w: command exited with error: %v", ErrConnectionLost, err)
+	}
+
+	return result, nil
+}
+
+// requestExecPty requests a pseudo-terminal for the session. When stdin is a terminal, it is put in raw mode and its size is kept in sync with the session until done is closed, and a default size is used otherwise.
+//
+// It returns a function restoring the terminal.
+func requestExecPty(session *ssh.Session, stdin io.Reader, done <-chan struct{}) (func(), error) {
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+
+	f, ok := stdin.(*os.File)
+	if !ok || !term.IsTerminal(f.Fd()) {
+		if err := session.RequestPty("xterm-256color", 24, 80, modes); err != nil {
+			return nil, fmt.Errorf("request for pseudo terminal failed: %v", err)
+		}
+
+		return func() {}, nil
+	}
+
+	w, h, err := term.GetSize(f.Fd())
+	if err != nil {
+		return nil, fmt.Errorf("cannot get terminal size: %v", err)
+	}
+
+	if err := session.RequestPty("xterm-256color", h, w, modes); err != nil {
+		return nil, fmt.Errorf("request for pseudo terminal failed: %v", err)
+	}
+
+	oldState, err := term.MakeRaw(f.Fd())
+	if err != nil {
+		return nil, fmt.Errorf("failed to set terminal into raw mode: %v", err)
+	}
+
+	go handleResize(session, done)
+
+	return func() {
+		term.Restore(f.Fd(), oldState)
+	}, nil
+}
@@ -0,0 +1,153 @@
+package connection
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ManagedSSHConfigFileName = "ssh_config"
+)
+
+// ExportSSHConfig writes an OpenSSH config file with a Host block for every connection, so that the inventory can be used by ssh, scp, rsync and other tools.
+func (cm ConnectionManager) ExportSSHConfig(w io.Writer) error {
+	aliases := cm.sshConfigAliases()
+
+	for i, conn := range cm.Connections {
+		var b strings.Builder
+
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		fmt.Fprintf(&b, "Host %s\n", aliases[conn.ID])
+		fmt.Fprintf(&b, "  HostName %s\n", conn.Host)
+		fmt.Fprintf(&b, "  User %s\n", conn.Username)
+		fmt.Fprintf(&b, "  Port %d\n", conn.Port)
+
+		for _, identityFile := range conn.IdentityFiles {
+			fmt.Fprintf(&b, "  IdentityFile %s\n", quoteSSHConfigArg(identityFile))
+		}
+
+		if conn.IdentitiesOnly {
+			b.WriteString("  IdentitiesOnly yes\n")
+		}
+
+		if !conn.KeySource.usesAgent() {
+			b.WriteString("  IdentityAgent none\n")
+		}
+
+		if conn.ForwardAgent {
+			b.WriteString("  ForwardAgent yes\n")
+		}
+
+		// the defaults of ssh-manager are not written, so that the ones of ssh apply
+		if conn.ServerAliveInterval != 0 {
+			fmt.Fprintf(&b, "  ServerAliveInterval %d\n", int(conn.KeepaliveInterval().Seconds()))
+		}
+
+		if conn.ServerAliveCountMax > 0 {
+			fmt.Fprintf(&b, "  ServerAliveCountMax %d\n", conn.ServerAliveCountMax)
+		}
+
+		if len(conn.JumpHosts) > 0 {
+			var jumps []string
+			for _, reference := range conn.JumpHosts {
+				if jump, ok := cm.Find(reference); ok {
+					reference = aliases[jump.ID]
+				}
+				jumps = append(jumps, reference)
+			}
+			fmt.Fprintf(&b, "  ProxyJump %s\n", strings.Join(jumps, ","))
+		}
+
+		for _, forward := range conn.Forwards {
+			switch forward.Type {
+			case ForwardLocal:
+				fmt.Fprintf(&b, "  LocalForward %s %s\n", forward.Bind, forward.Target)
+			case ForwardRemote:
+				fmt.Fprintf(&b, "  RemoteForward %s %s\n", forward.Bind, forward.Target)
+			case ForwardDynamic:
+				fmt.Fprintf(&b, "  DynamicForward %s\n", forward.Bind)
+			}
+		}
+
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return fmt.Errorf("failed to write ssh config: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ManagedSSHConfigPath returns the path to the OpenSSH config file that is regenerated every time the connections are saved, meant to be included from ~/.ssh/config.
+func ManagedSSHConfigPath() (string, error) {
+	storagePath, err := storageFilePath()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(storagePath), ManagedSSHConfigFileName), nil
+}
+
+// writeManagedSSHConfig regenerates the managed OpenSSH config file.
+func (cm ConnectionManager) writeManagedSSHConfig() error {
+	path, err := ManagedSSHConfigPath()
+	if err != nil {
+		return err
+	}
+
+	// the file is written at once, so that ssh never reads it half written
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Generated by ssh-manager, do not edit: changes are overwritten every time connections are saved.\n")
+	fmt.Fprintf(&b, "# Add \"Include %s\" to ~/.ssh/config to use these hosts with ssh, scp or rsync.\n\n", path)
+
+	if err := cm.ExportSSHConfig(&b); err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(path, b.Bytes(), StorageFilePerm); err != nil {
+		return fmt.Errorf("failed to write managed ssh config: %w", err)
+	}
+
+	return nil
+}
+
+// sshConfigAliases returns a unique Host alias for every connection, indexed by ID. The alias of the connection is used if it has one, then the host name when it is unique, and the user and port are added to it otherwise.
+func (cm ConnectionManager) sshConfigAliases() map[string]string {
+	nameCount := map[string]int{}
+	for _, conn := range cm.Connections {
+		if conn.Alias != "" {
+			nameCount[conn.Alias]++
+		} else {
+			nameCount[conn.Host]++
+		}
+	}
+
+	aliases := map[string]string{}
+	for _, conn := range cm.Connections {
+		alias := conn.Alias
+		if alias == "" {
+			alias = conn.Host
+			if nameCount[conn.Host] > 1 {
+				alias = fmt.Sprintf("%s-%s-%d", conn.Username, conn.Host, conn.Port)
+			}
+		}
+
+		aliases[conn.ID] = strings.NewReplacer(":", "-", "[", "", "]", "").Replace(alias)
+	}
+
+	return aliases
+}
+
+// quoteSSHConfigArg quotes an argument containing whitespace.
+func quoteSSHConfigArg(arg string) string {
+	if strings.ContainsAny(arg, " \t") {
+		return `"` + arg + `"`
+	}
+
+	return arg
+}
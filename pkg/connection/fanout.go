@@ -0,0 +1,141 @@
+package connection
+
+import (
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultParallelism is the number of connections a command runs on at the same time with ExecAll, unless set otherwise.
+const DefaultParallelism = 10
+
+// Selector selects connections by tag, group and name. Empty fields match every connection.
+type Selector struct {
+	Tag string
+	// Group matches the connections in the group or its subgroups
+	Group string
+	// Filter is a glob pattern matched against the ID, alias, host and destination of connections, e.g. web-*
+	Filter string
+}
+
+// Matches reports whether the connection is selected.
+func (s Selector) Matches(c Connection) bool {
+	if s.Tag != "" && !slices.Contains(c.Tags, s.Tag) {
+		return false
+	}
+
+	if s.Group != "" && c.Group != s.Group && !strings.HasPrefix(c.Group, s.Group+"/") {
+		return false
+	}
+
+	if s.Filter == "" {
+		return true
+	}
+
+	for _, name := range []string{c.ID, c.Alias, c.Host, c.Destination()} {
+		if matched, _ := path.Match(s.Filter, name); matched && name != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Select returns the connections matching the selector, in the order they are stored.
+func (cm ConnectionManager) Select(s Selector) []Connection {
+	connections := []Connection{}
+	for _, conn := range cm.Connections {
+		if s.Matches(conn) {
+			connections = append(connections, conn)
+		}
+	}
+
+	return connections
+}
+
+// ExecResult is the result of a command run on one of the connections given to ExecAll.
+type ExecResult struct {
+	Conn       Connection
+	ExitStatus int
+	Duration   time.Duration
+	// Err is set when the command could not be run, or its connection was lost
+	Err error
+}
+
+// ExecAllOptions describes a command to run on several connections with ExecAll.
+type ExecAllOptions struct {
+	// Command is the command line run by the remote shell of every connection
+	Command string
+	// Parallelism is the maximum number of connections the command runs on at the same time, DefaultParallelism if 0
+	Parallelism int
+	// Output returns the writers the output of the command run on the connection is written to, nil writers discarding it. All output is discarded if it is nil.
+	Output func(c Connection) (stdout io.Writer, stderr io.Writer)
+	// Done is called with the result of every connection as soon as the command ends on it, one at a time. It may be nil.
+	Done func(result ExecResult)
+}
+
+// ExecAll runs a command on all the given connections, with at most opts.Parallelism of them at the same time. Nothing can be prompted for, as prompts of several connections could not be told apart: unknown host keys are refused and keys protected by a passphrase are skipped, as with reachability checks. The vault is unlocked first if any connection needs it, which may prompt for its passphrase.
+//
+// It returns the results in the order of the connections.
+func (cm ConnectionManager) ExecAll(connections []Connection, opts ExecAllOptions) []ExecResult {
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+
+	cm.unlockVaultFor(connections)
+
+	results := make([]ExecResult, len(connections))
+	slots := make(chan struct{}, parallelism)
+	var doneMu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i, conn := range connections {
+		wg.Add(1)
+		slots <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			start := time.Now()
+			execOpts := ExecOptions{Command: opts.Command}
+			if opts.Output != nil {
+				execOpts.Stdout, execOpts.Stderr = opts.Output(conn)
+			}
+
+			sessionResult, err := cm.exec(conn, execOpts, false)
+			results[i] = ExecResult{Conn: conn, ExitStatus: sessionResult.ExitStatus, Duration: time.Since(start), Err: err}
+
+			if opts.Done != nil {
+				doneMu.Lock()
+				opts.Done(results[i])
+				doneMu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// unlockVaultFor reads the password of the first connection, or jump host, that needs the vault while it is locked, so that its passphrase is prompted for once rather than by every connection at the same time. Failures are left to be reported by the connections themselves.
+func (cm ConnectionManager) unlockVaultFor(connections []Connection) {
+	for _, conn := range connections {
+		jumps, err := cm.JumpChain(conn)
+		if err != nil {
+			continue
+		}
+
+		for _, hop := range append(jumps, conn) {
+			if cm.needsVaultUnlock([]Connection{hop}) {
+				cm.Password(hop)
+				return
+			}
+		}
+	}
+}
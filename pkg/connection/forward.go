@@ -0,0 +1,327 @@
+package connection
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ForwardType is the kind of port forwarding, matching ssh's -L, -R and -D options.
+type ForwardType string
+
+const (
+	ForwardLocal   ForwardType = "local"
+	ForwardRemote  ForwardType = "remote"
+	ForwardDynamic ForwardType = "dynamic"
+)
+
+// Forward is a port forwarding rule. Bind is the address listened on (locally for local and dynamic forwards, on the server for remote ones), and Target the address connections are forwarded to. Dynamic forwards have no target, as it is requested by the SOCKS5 client.
+type Forward struct {
+	Type   ForwardType
+	Bind   string
+	Target string
+}
+
+// ForwardStat holds the traffic going through a forward while a session is running.
+type ForwardStat struct {
+	Forward     Forward
+	Connections atomic.Int64
+	BytesIn     atomic.Int64
+	BytesOut    atomic.Int64
+}
+
+func (fs *ForwardStat) String() string {
+	return fmt.Sprintf("%s: %d connections, %s in, %s out", fs.Forward, fs.Connections.Load(), FormatBytes(fs.BytesIn.Load()), FormatBytes(fs.BytesOut.Load()))
+}
+
+// ParseForward parses a forward in the format of ssh's options, prefixed by its type: "L [bind_address:]port:host:hostport", "R [bind_address:]port:host:hostport" or "D [bind_address:]port".
+func ParseForward(spec string) (Forward, error) {
+	flag, rest, _ := strings.Cut(strings.TrimSpace(spec), " ")
+	rest = strings.TrimSpace(rest)
+
+	var forward Forward
+	switch strings.ToUpper(strings.TrimPrefix(flag, "-")) {
+	case "L":
+		forward.Type = ForwardLocal
+	case "R":
+		forward.Type = ForwardRemote
+	case "D":
+		forward.Type = ForwardDynamic
+	default:
+		return Forward{}, fmt.Errorf("invalid forward %q: must start with L, R or D", spec)
+	}
+
+	parts := splitForwardSpec(rest)
+
+	if forward.Type == ForwardDynamic {
+		switch len(parts) {
+		case 1:
+			forward.Bind = parts[0]
+		case 2:
+			forward.Bind = net.JoinHostPort(parts[0], parts[1])
+		default:
+			return Forward{}, fmt.Errorf("invalid dynamic forward %q: expected [bind_address:]port", spec)
+		}
+
+		return forward, nil
+	}
+
+	switch len(parts) {
+	case 3:
+		forward.Bind = parts[0]
+	case 4:
+		forward.Bind = net.JoinHostPort(parts[0], parts[1])
+		parts = parts[1:]
+	default:
+		return Forward{}, fmt.Errorf("invalid forward %q: expected [bind_address:]port:host:hostport", spec)
+	}
+	forward.Target = net.JoinHostPort(parts[1], parts[2])
+
+	return forward, nil
+}
+
+// splitForwardSpec splits a forward on colons, keeping bracketed IPv6 addresses together.
+func splitForwardSpec(spec string) []string {
+	var parts []string
+	var current strings.Builder
+	inBrackets := false
+
+	for _, r := range spec {
+		switch {
+		case r == '[':
+			inBrackets = true
+		case r == ']':
+			inBrackets = false
+		case r == ':' && !inBrackets:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	return append(parts, current.String())
+}
+
+// String returns the forward in the format accepted by ParseForward.
+func (f Forward) String() string {
+	switch f.Type {
+	case ForwardRemote:
+		return fmt.Sprintf("R %s:%s", f.Bind, f.Target)
+	case ForwardDynamic:
+		return fmt.Sprintf("D %s", f.Bind)
+	}
+
+	return fmt.Sprintf("L %s:%s", f.Bind, f.Target)
+}
+
+// bindAddress returns the address to listen on, which defaults to the loopback interface when only a port is given.
+func (f Forward) bindAddress() string {
+	if !strings.Contains(f.Bind, ":") {
+		return net.JoinHostPort("127.0.0.1", f.Bind)
+	}
+
+	return f.Bind
+}
+
+// ForwardSession holds the forwards of a connection opened without a shell, as with ssh -N, so that their traffic can be watched while they run.
+type ForwardSession struct {
+	Conn  Connection
+	Stats []*ForwardStat
+	// Start is when the forwards were opened
+	Start time.Time
+
+	client    *ssh.Client
+	listeners []net.Listener
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// OpenForwards dials the connection, going through its jump hosts first and prompting for what is needed to authenticate, as described in Dial, and starts its forwards without starting a shell. Keepalives are sent as for interactive sessions, the forwards ending if the connection is lost.
+//
+// It returns the running forwards, which have to be closed once done with them.
+func (cm ConnectionManager) OpenForwards(c Connection) (*ForwardSession, error) {
+	if len(c.Forwards) == 0 {
+		return nil, fmt.Errorf("%s has no forwards", c.Name())
+	}
+
+	client, err := cm.Dial(c)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := c.forwardStats()
+	listeners, err := startForwards(client, stats)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	fs := &ForwardSession{Conn: c, Stats: stats, Start: time.Now(), client: client, listeners: listeners, done: make(chan struct{})}
+
+	// a lost connection is noticed by Wait, as the client is closed
+	if interval := c.KeepaliveInterval(); interval > 0 {
+		go keepAlive(client, interval, c.KeepaliveCountMax(), fs.done, make(chan struct{}))
+	}
+
+	return fs, nil
+}
+
+// Wait blocks until the connection of the forwards ends, either because they were closed or because it was lost.
+//
+// It returns ErrConnectionLost if the forwards were not closed.
+func (fs *ForwardSession) Wait() error {
+	fs.client.Wait()
+
+	select {
+	case <-fs.done:
+		return nil
+	default:
+		return ErrConnectionLost
+	}
+}
+
+// Close stops the forwards and closes their connection. It can be called more than once.
+func (fs *ForwardSession) Close() error {
+	var err error
+	fs.closeOnce.Do(func() {
+		close(fs.done)
+		for _, listener := range fs.listeners {
+			listener.Close()
+		}
+		err = fs.client.Close()
+	})
+
+	return err
+}
+
+// forwardStats returns the statistics of the forwards of the connection, before any traffic went through them.
+func (c Connection) forwardStats() []*ForwardStat {
+	var stats []*ForwardStat
+	for _, forward := range c.Forwards {
+		stats = append(stats, &ForwardStat{Forward: forward})
+	}
+
+	return stats
+}
+
+// startForwards starts listening for the forwards of the given statistics on the client, counting their traffic in them. They can be started again on another client when reconnecting, adding to the traffic already counted. Connections are handled in the background until the returned listeners are closed.
+func startForwards(client *ssh.Client, stats []*ForwardStat) ([]net.Listener, error) {
+	var listeners []net.Listener
+
+	for _, stat := range stats {
+		forward := stat.Forward
+
+		var listener net.Listener
+		var err error
+
+		if forward.Type == ForwardRemote {
+			listener, err = client.Listen("tcp", forward.bindAddress())
+		} else {
+			listener, err = net.Listen("tcp", forward.bindAddress())
+		}
+
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("unable to start forward %s: %v", forward, err)
+		}
+
+		listeners = append(listeners, listener)
+
+		go serveForward(client, listener, stat)
+	}
+
+	return listeners, nil
+}
+
+// serveForward accepts connections on listener, and forwards them according to the forward type until the listener is closed.
+func serveForward(client *ssh.Client, listener net.Listener, stat *ForwardStat) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+
+			var target net.Conn
+			var err error
+
+			switch stat.Forward.Type {
+			case ForwardLocal:
+				target, err = client.Dial("tcp", stat.Forward.Target)
+			case ForwardRemote:
+				target, err = net.Dial("tcp", stat.Forward.Target)
+			case ForwardDynamic:
+				target, err = serveSOCKS5(conn, func(address string) (net.Conn, error) {
+					return client.Dial("tcp", address)
+				})
+			}
+
+			if err != nil {
+				return
+			}
+			defer target.Close()
+
+			stat.Connections.Add(1)
+			pipe(conn, target, stat)
+		}()
+	}
+}
+
+// pipe copies data both ways between the accepted connection and its target, counting the bytes going through, until either side is closed.
+func pipe(conn net.Conn, target net.Conn, stat *ForwardStat) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		n, _ := io.Copy(target, conn)
+		stat.BytesOut.Add(n)
+		closeWrite(target)
+	}()
+
+	go func() {
+		defer wg.Done()
+		n, _ := io.Copy(conn, target)
+		stat.BytesIn.Add(n)
+		closeWrite(conn)
+	}()
+
+	wg.Wait()
+}
+
+// closeWrite half-closes the connection if it supports it, so that the other side knows no more data will be sent.
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+		return
+	}
+
+	conn.Close()
+}
+
+// FormatBytes formats a number of bytes in a human readable way.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for i := n / unit; i >= unit; i /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,98 @@
+package connection
+
+import "testing"
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    Forward
+		wantErr bool
+	}{
+		{
+			name: "local forward",
+			spec: "L 5432:db:5432",
+			want: Forward{Type: ForwardLocal, Bind: "5432", Target: "db:5432"},
+		},
+		{
+			name: "local forward with bind address",
+			spec: "L 0.0.0.0:8080:web:80",
+			want: Forward{Type: ForwardLocal, Bind: "0.0.0.0:8080", Target: "web:80"},
+		},
+		{
+			name: "remote forward",
+			spec: "R 9000:localhost:3000",
+			want: Forward{Type: ForwardRemote, Bind: "9000", Target: "localhost:3000"},
+		},
+		{
+			name: "dynamic forward",
+			spec: "D 1080",
+			want: Forward{Type: ForwardDynamic, Bind: "1080"},
+		},
+		{
+			name: "dynamic forward with bind address",
+			spec: "D 127.0.0.1:1080",
+			want: Forward{Type: ForwardDynamic, Bind: "127.0.0.1:1080"},
+		},
+		{
+			name: "lowercase type with dash and extra spaces",
+			spec: "  -l   5432:db:5432 ",
+			want: Forward{Type: ForwardLocal, Bind: "5432", Target: "db:5432"},
+		},
+		{
+			name: "bracketed IPv6 addresses",
+			spec: "L [::1]:8080:[fd00::2]:80",
+			want: Forward{Type: ForwardLocal, Bind: "[::1]:8080", Target: "[fd00::2]:80"},
+		},
+		{
+			name:    "unknown type",
+			spec:    "X 5432:db:5432",
+			wantErr: true,
+		},
+		{
+			name:    "missing type",
+			spec:    "5432:db:5432",
+			wantErr: true,
+		},
+		{
+			name:    "missing target port",
+			spec:    "L 5432:db",
+			wantErr: true,
+		},
+		{
+			name:    "too many parts",
+			spec:    "L a:1:b:2:c",
+			wantErr: true,
+		},
+		{
+			name:    "dynamic forward with target",
+			spec:    "D 1080:db:5432",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseForward(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseForward(%q) = %+v, want an error", tt.spec, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseForward(%q) returned an error: %v", tt.spec, err)
+			}
+
+			if got != tt.want {
+				t.Fatalf("ParseForward(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+
+			// forwards are stored in the format they are parsed from
+			if again, err := ParseForward(got.String()); err != nil || again != got {
+				t.Fatalf("ParseForward(%q) = %+v, %v, want %+v", got.String(), again, err, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package connection
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+)
+
+// GroupItem is a group of connections in the tree view.
+type GroupItem struct {
+	Path string
+	// Count is the number of connections in the group and its subgroups
+	Count     int
+	Collapsed bool
+	// Depth is the number of groups the group is nested in
+	Depth int
+}
+
+func (g GroupItem) Title() string {
+	arrow := "▾"
+	if g.Collapsed {
+		arrow = "▸"
+	}
+
+	return fmt.Sprintf("%s%s %s", indent(g.Depth), arrow, path.Base(g.Path))
+}
+
+func (g GroupItem) Description() string {
+	if g.Count == 1 {
+		return indent(g.Depth) + "1 connection"
+	}
+
+	return fmt.Sprintf("%s%d connections", indent(g.Depth), g.Count)
+}
+
+func (g GroupItem) FilterValue() string {
+	return g.Path
+}
+
+// groupNode is a group of the tree, holding its subgroups and the connections directly in it.
+type groupNode struct {
+	path        string
+	subgroups   map[string]*groupNode
+	connections []Connection
+	count       int
+}
+
+// Items returns the list items of the tree view: groups come first, sorted by name, followed by the connections that are directly in them. Connections of collapsed groups are not included.
+//
+// If tag is not empty, only the connections with this tag are included, and groups without any of them are left out.
+func (cm ConnectionManager) Items(tag string) []list.Item {
+	root := &groupNode{subgroups: map[string]*groupNode{}}
+
+	for _, conn := range cm.Connections {
+		if tag != "" && !slices.Contains(conn.Tags, tag) {
+			continue
+		}
+
+		node := root
+		node.count++
+		if conn.Group != "" {
+			for _, name := range strings.Split(conn.Group, "/") {
+				subgroup, ok := node.subgroups[name]
+				if !ok {
+					subgroup = &groupNode{path: strings.TrimPrefix(node.path+"/"+name, "/"), subgroups: map[string]*groupNode{}}
+					node.subgroups[name] = subgroup
+				}
+
+				node = subgroup
+				node.count++
+			}
+		}
+
+		node.connections = append(node.connections, conn)
+	}
+
+	items := []list.Item{}
+	cm.appendGroupItems(&items, root, 0)
+
+	return items
+}
+
+// appendGroupItems appends the subgroups and connections of a group node to items, recursively.
+func (cm ConnectionManager) appendGroupItems(items *[]list.Item, node *groupNode, depth int) {
+	names := make([]string, 0, len(node.subgroups))
+	for name := range node.subgroups {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		subgroup := node.subgroups[name]
+		collapsed := slices.Contains(cm.CollapsedGroups, subgroup.path)
+
+		*items = append(*items, GroupItem{Path: subgroup.path, Count: subgroup.count, Collapsed: collapsed, Depth: depth})
+		if !collapsed {
+			cm.appendGroupItems(items, subgroup, depth+1)
+		}
+	}
+
+	for _, conn := range node.connections {
+		*items = append(*items, cm.item(conn, depth))
+	}
+}
+
+// ToggleGroup collapses the group with the given path in the tree view, or expands it if it is already collapsed.
+func (cm *ConnectionManager) ToggleGroup(groupPath string) error {
+	if i := slices.Index(cm.CollapsedGroups, groupPath); i >= 0 {
+		cm.CollapsedGroups = slices.Delete(cm.CollapsedGroups, i, i+1)
+	} else {
+		cm.CollapsedGroups = append(cm.CollapsedGroups, groupPath)
+	}
+
+	if err := cm.SaveToDisk(); err != nil {
+		return fmt.Errorf("failed to save to disk after toggling group: %w", err)
+	}
+
+	return nil
+}
+
+// Tags returns the tags used by the connections, sorted by name.
+func (cm ConnectionManager) Tags() []string {
+	var tags []string
+	for _, conn := range cm.Connections {
+		for _, tag := range conn.Tags {
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	slices.Sort(tags)
+
+	return tags
+}
+
+// normalizeGroup cleans up a group path, removing surrounding whitespace and empty segments, e.g. "/prod//db " becomes "prod/db".
+func normalizeGroup(group string) string {
+	var segments []string
+	for _, segment := range strings.Split(group, "/") {
+		if segment = strings.TrimSpace(segment); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// normalizeTags removes the leading # and surrounding whitespace of tags, as well as empty and duplicate ones.
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+
+	return normalized
+}
+
+// indent returns the indentation of an item nested in depth groups.
+func indent(depth int) string {
+	return strings.Repeat("  ", depth)
+}
@@ -0,0 +1,287 @@
+package connection
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	KnownHostsDirPerm  = 0700
+	KnownHostsFilePerm = 0600
+)
+
+// HostKeyStatus describes how a server's host key compares to the entries in known_hosts.
+type HostKeyStatus int
+
+const (
+	HostKeyKnown HostKeyStatus = iota
+	HostKeyUnknown
+	HostKeyChanged
+	HostKeyRevoked
+)
+
+// HostKeyPrompt is called when a server presents a host key that is not in known_hosts yet. Returning true trusts the key and adds it to known_hosts.
+type HostKeyPrompt func(address string, key ssh.PublicKey) (bool, error)
+
+// errHostKeyCaptured is used to abort a handshake once the host key has been received.
+var errHostKeyCaptured = errors.New("host key captured")
+
+// Address returns the host and port of the connection in the format used to dial it and to look it up in known_hosts.
+func (c Connection) Address() string {
+	port := c.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+
+	return net.JoinHostPort(c.Host, strconv.Itoa(port))
+}
+
+// CheckHostKey connects to the server without authenticating, and compares the host key it presents against known_hosts.
+//
+// It returns the status of the key along with the key itself, so that it can be shown to the user and trusted with AddKnownHost.
+func (c Connection) CheckHostKey() (HostKeyStatus, ssh.PublicKey, error) {
+	var hostKey ssh.PublicKey
+	var remoteAddr net.Addr
+
+	config := &ssh.ClientConfig{
+		User: c.Username,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			remoteAddr = remote
+			return errHostKeyCaptured
+		},
+		HostKeyAlgorithms: knownHostKeyAlgorithms(c.Address()),
+	}
+
+	// unreachable or unresponsive hosts give up as reachability checks do, instead of blocking the connection until the system does
+	conn, err := net.DialTimeout("tcp", c.Address(), DefaultCheckTimeout)
+	if err == nil {
+		conn.SetDeadline(time.Now().Add(DefaultCheckTimeout))
+		_, _, _, err = ssh.NewClientConn(conn, c.Address(), config)
+		conn.Close()
+	}
+
+	if hostKey == nil {
+		return HostKeyUnknown, nil, fmt.Errorf("unable to get host key for %s: %v", c.Address(), err)
+	}
+
+	callback, err := knownHostsCallback()
+	if err != nil {
+		return HostKeyUnknown, nil, err
+	}
+
+	return hostKeyStatus(callback(c.Address(), remoteAddr, hostKey)), hostKey, nil
+}
+
+// AddKnownHost appends a hashed entry for the given address and key to known_hosts.
+func AddKnownHost(address string, key ssh.PublicKey) error {
+	path, err := ensureKnownHostsFile()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, KnownHostsFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts: %w", err)
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.HashHostname(knownhosts.Normalize(address))}, key)
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		return fmt.Errorf("failed to write to known_hosts: %w", err)
+	}
+
+	return nil
+}
+
+// Fingerprint returns the SHA256 fingerprint of a key, as displayed by OpenSSH.
+func Fingerprint(key ssh.PublicKey) string {
+	return ssh.FingerprintSHA256(key)
+}
+
+// hostKeyCallback returns a callback verifying host keys against known_hosts. Unknown keys are passed to prompt, and refused if prompt is nil.
+func hostKeyCallback(prompt HostKeyPrompt) (ssh.HostKeyCallback, error) {
+	callback, err := knownHostsCallback()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+
+		switch hostKeyStatus(err) {
+		case HostKeyKnown:
+			return nil
+		case HostKeyRevoked:
+			return fmt.Errorf("host key %s for %s has been revoked, refusing to connect", Fingerprint(key), hostname)
+		case HostKeyChanged:
+			var keyErr *knownhosts.KeyError
+			errors.As(err, &keyErr)
+			return fmt.Errorf("host key for %s has changed (now %s, expected key from %s:%d), refusing to connect as this could be a man-in-the-middle attack", hostname, Fingerprint(key), keyErr.Want[0].Filename, keyErr.Want[0].Line)
+		}
+
+		if prompt == nil {
+			return fmt.Errorf("host key %s for %s is not in known_hosts", Fingerprint(key), hostname)
+		}
+
+		trusted, err := prompt(hostname, key)
+		if err != nil {
+			return err
+		}
+
+		if !trusted {
+			return fmt.Errorf("host key %s for %s was not trusted", Fingerprint(key), hostname)
+		}
+
+		return AddKnownHost(hostname, key)
+	}, nil
+}
+
+// hostKeyStatus converts an error returned by a knownhosts callback into a HostKeyStatus.
+func hostKeyStatus(err error) HostKeyStatus {
+	var keyErr *knownhosts.KeyError
+	var revokedErr *knownhosts.RevokedError
+
+	switch {
+	case err == nil:
+		return HostKeyKnown
+	case errors.As(err, &revokedErr):
+		return HostKeyRevoked
+	case errors.As(err, &keyErr) && len(keyErr.Want) > 0:
+		return HostKeyChanged
+	}
+
+	return HostKeyUnknown
+}
+
+// knownHostKeyAlgorithms returns the key algorithms known_hosts holds for the given address, so that the server is asked for a key we can actually verify.
+func knownHostKeyAlgorithms(address string) []string {
+	callback, err := knownHostsCallback()
+	if err != nil {
+		return nil
+	}
+
+	// an empty key never matches, so the error lists all the known keys for this address
+	var keyErr *knownhosts.KeyError
+	if err := callback(address, &net.TCPAddr{}, emptyKey{}); !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	seen := map[string]bool{}
+	for _, known := range keyErr.Want {
+		for _, algorithm := range hostKeyAlgorithmsFor(known.Key.Type()) {
+			if !seen[algorithm] {
+				seen[algorithm] = true
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+
+	return algorithms
+}
+
+// hostKeyAlgorithmsFor returns the signature algorithms that can be negotiated for a given key type.
+func hostKeyAlgorithmsFor(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+
+	return []string{keyType}
+}
+
+// knownHostsCallback returns the knownhosts callback for the user's known_hosts file, creating the file if needed.
+func knownHostsCallback() (ssh.HostKeyCallback, error) {
+	path, err := ensureKnownHostsFile()
+	if err != nil {
+		return nil, err
+	}
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+
+	return callback, nil
+}
+
+// knownHostsPath returns the path to the user's known_hosts file.
+func knownHostsPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to get user home directory: %v", err)
+	}
+
+	return filepath.Join(homeDir, ".ssh", "known_hosts"), nil
+}
+
+// ensureKnownHostsFile ensures that known_hosts exists, and returns its path.
+func ensureKnownHostsFile() (string, error) {
+	path, err := knownHostsPath()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), KnownHostsDirPerm); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, KnownHostsFilePerm)
+	if err != nil {
+		return "", fmt.Errorf("failed to create known_hosts: %w", err)
+	}
+
+	return path, f.Close()
+}
+
+// terminalHostKeyPrompt asks the user on the terminal whether an unknown host key should be trusted, the same way OpenSSH does.
+func terminalHostKeyPrompt(address string, key ssh.PublicKey) (bool, error) {
+	in, out, closeTerminal := openTerminal()
+	defer closeTerminal()
+
+	fmt.Fprintf(out, "The authenticity of host '%s' can't be established.\n", address)
+	fmt.Fprintf(out, "%s key fingerprint is %s.\n", strings.ToUpper(strings.TrimPrefix(key.Type(), "ssh-")), Fingerprint(key))
+	fmt.Fprint(out, "Are you sure you want to continue connecting (yes/no)? ")
+
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil {
+		return false, fmt.Errorf("failed to read answer: %w", err)
+	}
+
+	return strings.TrimSpace(strings.ToLower(answer)) == "yes", nil
+}
+
+// emptyKey is a public key that never matches anything, used to list the keys known for a host.
+type emptyKey struct{}
+
+func (emptyKey) Type() string                        { return "" }
+func (emptyKey) Marshal() []byte                     { return []byte{} }
+func (emptyKey) Verify([]byte, *ssh.Signature) error { return nil }
+
+// VerifyHostKey is a helper function for bubbletea. It checks the host key of the connection against known_hosts, so that unknown hosts can be confirmed by the user before connecting.
+//
+// It returns a HostKeyCheckedMsg message.
+func (c Connection) VerifyHostKey() tea.Msg {
+	status, key, err := c.CheckHostKey()
+
+	return HostKeyCheckedMsg{Conn: c, Status: status, Key: key, Err: err}
+}
+
+// HostKeyCheckedMsg is a bubbletea message that is sent when the host key of a connection has been checked.
+type HostKeyCheckedMsg struct {
+	Conn   Connection
+	Status HostKeyStatus
+	Key    ssh.PublicKey
+	Err    error
+}
@@ -0,0 +1,93 @@
+package connection
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// DefaultServerAliveInterval is the number of seconds between keepalives, for connections that do not set ServerAliveInterval
+	DefaultServerAliveInterval = 30
+	// DefaultServerAliveCountMax is the number of unanswered keepalives after which the connection is lost, for connections that do not set ServerAliveCountMax
+	DefaultServerAliveCountMax = 3
+	// MaxReconnectAttempts is the number of attempts in a row to reconnect a lost session before giving up
+	MaxReconnectAttempts = 10
+	minReconnectDelay    = time.Second
+	maxReconnectDelay    = 30 * time.Second
+)
+
+// ErrConnectionLost is returned when a session ends because the connection to the server dropped, or the server stopped answering keepalives.
+var ErrConnectionLost = errors.New("connection lost")
+
+// KeepaliveInterval returns the time between keepalives sent to the server, or 0 if they are disabled.
+func (c Connection) KeepaliveInterval() time.Duration {
+	switch {
+	case c.ServerAliveInterval < 0:
+		return 0
+	case c.ServerAliveInterval == 0:
+		return DefaultServerAliveInterval * time.Second
+	}
+
+	return time.Duration(c.ServerAliveInterval) * time.Second
+}
+
+// KeepaliveCountMax returns the number of keepalives in a row the server can leave unanswered.
+func (c Connection) KeepaliveCountMax() int {
+	if c.ServerAliveCountMax <= 0 {
+		return DefaultServerAliveCountMax
+	}
+
+	return c.ServerAliveCountMax
+}
+
+// keepAlive sends a keepalive request to the server every interval, until done is closed. Any reply counts, as servers that do not know the request still answer it. When countMax keepalives in a row go unanswered, the client is closed so that the session using it ends, and lost is closed.
+//
+// Meant to be used as a goroutine.
+func keepAlive(client *ssh.Client, interval time.Duration, countMax int, done <-chan struct{}, lost chan<- struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// only one request is sent at a time, a request that is still pending when the next one is due counts as unanswered
+	replies := make(chan error, 1)
+	pending := false
+	missed := 0
+
+	for {
+		select {
+		case <-done:
+			return
+		case err := <-replies:
+			pending = false
+			if err == nil {
+				missed = 0
+			}
+		case <-ticker.C:
+			if missed >= countMax {
+				close(lost)
+				client.Close()
+				return
+			}
+
+			missed++
+			if !pending {
+				pending = true
+				go func() {
+					_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+					replies <- err
+				}()
+			}
+		}
+	}
+}
+
+// reconnectDelay returns how long to wait before the given attempt to reconnect, starting at 0, doubling after every failed attempt.
+func reconnectDelay(attempt int) time.Duration {
+	delay := minReconnectDelay
+	for i := 0; i < attempt && delay < maxReconnectDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxReconnectDelay)
+}
@@ -0,0 +1,79 @@
+package connection
+
+import "reflect"
+
+// mergeManagers merges the changes made to base in ours and theirs. Connections are matched by ID: a connection changed on one side only takes that change, including being deleted, and a connection changed on both sides takes the one from ours. Settings are merged the same way.
+//
+// It returns the merged connections, and the names of the connections changed on both sides.
+func mergeManagers(base ConnectionManager, ours ConnectionManager, theirs ConnectionManager) (ConnectionManager, []string) {
+	merged := theirs
+	merged.Connections = nil
+
+	if !reflect.DeepEqual(ours.Secrets, base.Secrets) {
+		merged.Secrets = ours.Secrets
+	}
+	if !reflect.DeepEqual(ours.Checks, base.Checks) {
+		merged.Checks = ours.Checks
+	}
+	if !reflect.DeepEqual(ours.CollapsedGroups, base.CollapsedGroups) {
+		merged.CollapsedGroups = ours.CollapsedGroups
+	}
+
+	baseConns := connectionsByID(base)
+	oursConns := connectionsByID(ours)
+	theirsConns := connectionsByID(theirs)
+
+	var conflicts []string
+	for _, their := range theirs.Connections {
+		original, inBase := baseConns[their.ID]
+		our, inOurs := oursConns[their.ID]
+		theirsChanged := !inBase || !reflect.DeepEqual(their, original)
+
+		switch {
+		case !inBase && !inOurs:
+			// added on disk
+			merged.Connections = append(merged.Connections, their)
+		case !inOurs:
+			// deleted locally
+			if theirsChanged {
+				conflicts = append(conflicts, their.Name())
+			}
+		case inBase && reflect.DeepEqual(our, original):
+			merged.Connections = append(merged.Connections, their)
+		default:
+			if theirsChanged && !reflect.DeepEqual(our, their) {
+				conflicts = append(conflicts, our.Name())
+			}
+			merged.Connections = append(merged.Connections, our)
+		}
+	}
+
+	for _, our := range ours.Connections {
+		if _, ok := theirsConns[our.ID]; ok {
+			continue
+		}
+
+		original, inBase := baseConns[our.ID]
+		switch {
+		case !inBase:
+			// added locally
+			merged.Connections = append(merged.Connections, our)
+		case !reflect.DeepEqual(our, original):
+			// changed locally, but deleted on disk
+			conflicts = append(conflicts, our.Name())
+			merged.Connections = append(merged.Connections, our)
+		}
+	}
+
+	return merged, conflicts
+}
+
+// connectionsByID returns the connections of the manager indexed by ID.
+func connectionsByID(cm ConnectionManager) map[string]Connection {
+	connections := make(map[string]Connection, len(cm.Connections))
+	for _, conn := range cm.Connections {
+		connections[conn.ID] = conn
+	}
+
+	return connections
+}
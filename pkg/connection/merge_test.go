@@ -0,0 +1,222 @@
+package connection
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeManagers(t *testing.T) {
+	web := Connection{ID: "web", Username: "root", Host: "web", Port: 22}
+	db := Connection{ID: "db", Username: "root", Host: "db", Port: 22}
+	cache := Connection{ID: "cache", Username: "root", Host: "cache", Port: 22}
+
+	// renamed returns the connection with another alias, as changed on one side
+	renamed := func(conn Connection, alias string) Connection {
+		conn.Alias = alias
+		return conn
+	}
+
+	tests := []struct {
+		name          string
+		base          ConnectionManager
+		ours          ConnectionManager
+		theirs        ConnectionManager
+		want          ConnectionManager
+		wantConflicts []string
+	}{
+		{
+			name:   "nothing changed",
+			base:   ConnectionManager{Connections: []Connection{web, db}},
+			ours:   ConnectionManager{Connections: []Connection{web, db}},
+			theirs: ConnectionManager{Connections: []Connection{web, db}},
+			want:   ConnectionManager{Connections: []Connection{web, db}},
+		},
+		{
+			name:   "added on both sides",
+			base:   ConnectionManager{Connections: []Connection{web}},
+			ours:   ConnectionManager{Connections: []Connection{web, cache}},
+			theirs: ConnectionManager{Connections: []Connection{web, db}},
+			want:   ConnectionManager{Connections: []Connection{web, db, cache}},
+		},
+		{
+			name:   "deleted on disk",
+			base:   ConnectionManager{Connections: []Connection{web, db}},
+			ours:   ConnectionManager{Connections: []Connection{web, db}},
+			theirs: ConnectionManager{Connections: []Connection{web}},
+			want:   ConnectionManager{Connections: []Connection{web}},
+		},
+		{
+			name:   "deleted locally",
+			base:   ConnectionManager{Connections: []Connection{web, db}},
+			ours:   ConnectionManager{Connections: []Connection{web}},
+			theirs: ConnectionManager{Connections: []Connection{web, db}},
+			want:   ConnectionManager{Connections: []Connection{web}},
+		},
+		{
+			name:          "deleted locally and changed on disk",
+			base:          ConnectionManager{Connections: []Connection{web, db}},
+			ours:          ConnectionManager{Connections: []Connection{web}},
+			theirs:        ConnectionManager{Connections: []Connection{web, renamed(db, "theirs")}},
+			want:          ConnectionManager{Connections: []Connection{web}},
+			wantConflicts: []string{"theirs"},
+		},
+		{
+			name:          "changed locally and deleted on disk",
+			base:          ConnectionManager{Connections: []Connection{web, db}},
+			ours:          ConnectionManager{Connections: []Connection{web, renamed(db, "ours")}},
+			theirs:        ConnectionManager{Connections: []Connection{web}},
+			want:          ConnectionManager{Connections: []Connection{web, renamed(db, "ours")}},
+			wantConflicts: []string{"ours"},
+		},
+		{
+			name:   "changed on different connections",
+			base:   ConnectionManager{Connections: []Connection{web, db}},
+			ours:   ConnectionManager{Connections: []Connection{renamed(web, "ours"), db}},
+			theirs: ConnectionManager{Connections: []Connection{web, renamed(db, "theirs")}},
+			want:   ConnectionManager{Connections: []Connection{renamed(web, "ours"), renamed(db, "theirs")}},
+		},
+		{
+			name:   "changed the same way on both sides",
+			base:   ConnectionManager{Connections: []Connection{web}},
+			ours:   ConnectionManager{Connections: []Connection{renamed(web, "same")}},
+			theirs: ConnectionManager{Connections: []Connection{renamed(web, "same")}},
+			want:   ConnectionManager{Connections: []Connection{renamed(web, "same")}},
+		},
+		{
+			name:          "changed differently on both sides",
+			base:          ConnectionManager{Connections: []Connection{web}},
+			ours:          ConnectionManager{Connections: []Connection{renamed(web, "ours")}},
+			theirs:        ConnectionManager{Connections: []Connection{renamed(web, "theirs")}},
+			want:          ConnectionManager{Connections: []Connection{renamed(web, "ours")}},
+			wantConflicts: []string{"ours"},
+		},
+		{
+			name:   "settings changed on each side",
+			base:   ConnectionManager{Secrets: SecretSettings{Backend: "pass"}, Checks: CheckSettings{Interval: "1m"}},
+			ours:   ConnectionManager{Secrets: SecretSettings{Backend: "vault"}, Checks: CheckSettings{Interval: "1m"}, CollapsedGroups: []string{"prod"}},
+			theirs: ConnectionManager{Secrets: SecretSettings{Backend: "gopass"}, Checks: CheckSettings{Interval: "5m"}},
+			want:   ConnectionManager{Secrets: SecretSettings{Backend: "vault"}, Checks: CheckSettings{Interval: "5m"}, CollapsedGroups: []string{"prod"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflicts := mergeManagers(tt.base, tt.ours, tt.theirs)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got merged connections\n%+v\nwant\n%+v", got, tt.want)
+			}
+
+			if !reflect.DeepEqual(conflicts, tt.wantConflicts) {
+				t.Errorf("got conflicts %q, want %q", conflicts, tt.wantConflicts)
+			}
+		})
+	}
+}
+
+func TestSaveMerged(t *testing.T) {
+	tests := []struct {
+		name string
+		// ours and theirs change the connections loaded by two instances, theirs being saved first
+		ours          func(cm *ConnectionManager)
+		theirs        func(cm *ConnectionManager)
+		wantHosts     []string
+		wantConflicts []string
+	}{
+		{
+			name: "added by both instances",
+			ours: func(cm *ConnectionManager) {
+				cm.Connections = append(cm.Connections, Connection{ID: "ours", Username: "root", Host: "ours", Port: 22})
+			},
+			theirs: func(cm *ConnectionManager) {
+				cm.Connections = append(cm.Connections, Connection{ID: "theirs", Username: "root", Host: "theirs", Port: 22})
+			},
+			wantHosts: []string{"web", "theirs", "ours"},
+		},
+		{
+			name: "changed by both instances",
+			ours: func(cm *ConnectionManager) {
+				cm.Connections[0].Host = "ours"
+			},
+			theirs: func(cm *ConnectionManager) {
+				cm.Connections[0].Host = "theirs"
+			},
+			wantHosts:     []string{"ours"},
+			wantConflicts: []string{"root@ours:22"},
+		},
+		{
+			name: "deleted by the other instance while settings changed",
+			ours: func(cm *ConnectionManager) {
+				cm.CollapsedGroups = []string{"prod"}
+			},
+			theirs: func(cm *ConnectionManager) {
+				cm.Connections = nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetConfigPath(filepath.Join(t.TempDir(), StorageFileName))
+			t.Cleanup(func() { SetConfigPath("") })
+
+			initial, err := LoadConnections()
+			if err != nil {
+				t.Fatal(err)
+			}
+			initial.Connections = []Connection{{ID: "web", Username: "root", Host: "web", Port: 22}}
+			if err := initial.SaveToDisk(); err != nil {
+				t.Fatal(err)
+			}
+
+			ours, err := LoadConnections()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			theirs, err := LoadConnections()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.theirs(&theirs)
+			if err := theirs.SaveToDisk(); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.ours(&ours)
+			if err := ours.SaveToDisk(); !errors.Is(err, ErrStorageModified) {
+				t.Fatalf("SaveToDisk returned %v, want ErrStorageModified", err)
+			}
+
+			conflicts, err := ours.SaveMerged()
+			if err != nil {
+				t.Fatalf("SaveMerged returned an error: %v", err)
+			}
+
+			if !reflect.DeepEqual(conflicts, tt.wantConflicts) {
+				t.Errorf("got conflicts %q, want %q", conflicts, tt.wantConflicts)
+			}
+
+			// the merged connections are saved, and can be saved again without merging
+			saved, err := LoadConnections()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var hosts []string
+			for _, conn := range saved.Connections {
+				hosts = append(hosts, conn.Host)
+			}
+
+			if !reflect.DeepEqual(hosts, tt.wantHosts) {
+				t.Errorf("got hosts %q, want %q", hosts, tt.wantHosts)
+			}
+
+			if err := ours.SaveToDisk(); err != nil {
+				t.Errorf("SaveToDisk after merging returned an error: %v", err)
+			}
+		})
+	}
+}
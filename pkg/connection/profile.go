@@ -0,0 +1,171 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// ConfigEnvVar is the environment variable overriding the path of the storage file, like SetConfigPath
+	ConfigEnvVar = "SSH_MANAGER_CONFIG"
+	// ProfileEnvVar is the environment variable selecting the profile, like SetProfile
+	ProfileEnvVar = "SSH_MANAGER_PROFILE"
+	// DefaultProfile is the profile whose connections are stored directly in the ssh-manager config directory
+	DefaultProfile         = "default"
+	ProfilesDirName        = "profiles"
+	currentProfileFileName = "profile"
+)
+
+var (
+	// configPath overrides the path of the storage file, ignoring profiles
+	configPath string
+	// profile is the profile used by this process, resolved the first time it is needed so that it cannot change while connections are loaded
+	profile string
+)
+
+// SetConfigPath makes the connections be stored in the file at path, instead of the file of the active profile. It takes precedence over SSH_MANAGER_CONFIG.
+func SetConfigPath(path string) {
+	configPath = path
+}
+
+// configPathOverride returns the path of the storage file given with SetConfigPath or SSH_MANAGER_CONFIG, if any.
+func configPathOverride() string {
+	if configPath != "" {
+		return configPath
+	}
+
+	return os.Getenv(ConfigEnvVar)
+}
+
+// UsesProfiles reports whether connections are stored in the file of a profile, i.e. the storage file has not been overridden with SetConfigPath or SSH_MANAGER_CONFIG.
+func UsesProfiles() bool {
+	return configPathOverride() == ""
+}
+
+// SetProfile makes this process use the connections of the given profile. It takes precedence over SSH_MANAGER_PROFILE and the profile chosen with UseProfile.
+func SetProfile(name string) error {
+	if err := ValidateProfile(name); err != nil {
+		return err
+	}
+
+	profile = name
+	return nil
+}
+
+// UseProfile makes the given profile the active one, for this process and the next ones.
+func UseProfile(name string) error {
+	if err := SetProfile(name); err != nil {
+		return err
+	}
+
+	dir, err := configDir()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, StorageDirPerm); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, currentProfileFileName), []byte(name+"\n"), StorageFilePerm); err != nil {
+		return fmt.Errorf("failed to save active profile: %w", err)
+	}
+
+	return nil
+}
+
+// ActiveProfile returns the profile whose connections are used: the one given with SetProfile, then SSH_MANAGER_PROFILE, then the one chosen with UseProfile, and the default profile otherwise.
+func ActiveProfile() (string, error) {
+	if profile != "" {
+		return profile, nil
+	}
+
+	name := os.Getenv(ProfileEnvVar)
+	if name == "" {
+		dir, err := configDir()
+		if err != nil {
+			return "", err
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, currentProfileFileName))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to read active profile: %w", err)
+		}
+		name = strings.TrimSpace(string(b))
+	}
+
+	if name == "" {
+		name = DefaultProfile
+	}
+
+	if err := SetProfile(name); err != nil {
+		return "", fmt.Errorf("invalid active profile: %w", err)
+	}
+
+	return profile, nil
+}
+
+// Profiles returns the names of the existing profiles, sorted, starting with the default profile. The active profile is included even if nothing has been stored in it yet.
+func Profiles() ([]string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, ProfilesDirName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to list profiles: %w", err)
+	}
+
+	active, err := ActiveProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []string
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != DefaultProfile && ValidateProfile(entry.Name()) == nil {
+			profiles = append(profiles, entry.Name())
+		}
+	}
+
+	if active != DefaultProfile && !slices.Contains(profiles, active) {
+		profiles = append(profiles, active)
+	}
+	slices.Sort(profiles)
+
+	return append([]string{DefaultProfile}, profiles...), nil
+}
+
+// ValidateProfile checks that name can be used as the name of a profile. It may only contain alphanumerics, dashes, underscores and dots, as it is used as a directory name.
+func ValidateProfile(name string) error {
+	if name == "" {
+		return errors.New("profile name cannot be empty")
+	}
+
+	if name == "." || name == ".." {
+		return fmt.Errorf("invalid profile name %q", name)
+	}
+
+	for _, r := range name {
+		if !isAlphanumeric(r) && r != '-' && r != '_' && r != '.' {
+			return fmt.Errorf("profile name %q can only contain letters, digits, dashes, underscores and dots", name)
+		}
+	}
+
+	return nil
+}
+
+// configDir returns the ssh-manager directory in the user config directory, which holds the connections of the default profile.
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user config directory: %w", err)
+	}
+
+	return filepath.Join(dir, StorageDirPrefix), nil
+}
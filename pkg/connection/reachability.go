@@ -0,0 +1,272 @@
+package connection
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Defaults of the reachability check settings.
+const (
+	DefaultCheckInterval    = time.Minute
+	DefaultCheckTimeout     = 5 * time.Second
+	DefaultCheckConcurrency = 8
+)
+
+// maxBannerLines is how many lines a server can send before its version banner, as allowed by RFC 4253.
+const maxBannerLines = 16
+
+// ReachabilityStatus describes whether the server of a connection is up.
+type ReachabilityStatus string
+
+const (
+	ReachabilityUnknown  ReachabilityStatus = ""
+	ReachabilityChecking ReachabilityStatus = "checking"
+	// ReachabilityUp means that the server answered with an SSH banner
+	ReachabilityUp   ReachabilityStatus = "up"
+	ReachabilityDown ReachabilityStatus = "down"
+	// ReachabilityAuthenticated means that logging in succeeded, when checks authenticate
+	ReachabilityAuthenticated ReachabilityStatus = "authenticated"
+	// ReachabilityAuthFailed means that the server is up, but logging in failed
+	ReachabilityAuthFailed ReachabilityStatus = "auth failed"
+)
+
+// CheckSettings configures the reachability checks of the connections.
+type CheckSettings struct {
+	// Interval is how often connections are checked while the list is visible, such as "1m". Periodic checks are disabled if it is "0".
+	Interval string
+	// Timeout is how long to wait for each server, such as "5s"
+	Timeout string
+	// Concurrency is how many connections are checked at the same time
+	Concurrency int
+	// Authenticate makes checks log in to the servers, instead of only reading their banner
+	Authenticate bool
+}
+
+// CheckInterval returns how often connections are checked while the list is visible, or 0 if they are only checked on demand.
+func (cs CheckSettings) CheckInterval() (time.Duration, error) {
+	if cs.Interval == "" {
+		return DefaultCheckInterval, nil
+	}
+
+	interval, err := time.ParseDuration(cs.Interval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid check interval: %v", err)
+	}
+
+	return interval, nil
+}
+
+// Reachability is the result of checking whether a connection is up.
+type Reachability struct {
+	Status ReachabilityStatus
+	// Latency is how long it took to open a TCP connection to the server
+	Latency time.Duration
+	// ServerVersion is the version the server announced in its banner, e.g. SSH-2.0-OpenSSH_9.6
+	ServerVersion string
+	// Via is the name of the jump host that was checked instead, for connections that cannot be reached directly
+	Via string
+	Err error
+}
+
+// String describes the reachability of a connection, e.g. "up 12ms OpenSSH_9.6" or "down: connection refused".
+func (r Reachability) String() string {
+	var description string
+
+	switch r.Status {
+	case ReachabilityUnknown:
+		return ""
+	case ReachabilityChecking:
+		description = "checking…"
+	case ReachabilityDown, ReachabilityAuthFailed:
+		description = fmt.Sprintf("%s: %s", r.Status, checkErrorReason(r.Err))
+	default:
+		latency := r.Latency.Round(time.Millisecond).String()
+		if r.Latency < time.Millisecond {
+			latency = "<1ms"
+		}
+
+		description = fmt.Sprintf("%s %s %s", r.Status, latency, strings.TrimPrefix(r.ServerVersion, "SSH-2.0-"))
+	}
+
+	if r.Via != "" {
+		description += " (via " + r.Via + ")"
+	}
+
+	return description
+}
+
+// ReachabilityChecker checks whether connections are up, limiting how many are checked at the same time.
+type ReachabilityChecker struct {
+	timeout      time.Duration
+	authenticate bool
+	slots        chan struct{}
+}
+
+// NewReachabilityChecker returns a checker configured from the given settings.
+func NewReachabilityChecker(settings CheckSettings) (*ReachabilityChecker, error) {
+	rc := &ReachabilityChecker{
+		timeout:      DefaultCheckTimeout,
+		authenticate: settings.Authenticate,
+	}
+
+	if settings.Timeout != "" {
+		var err error
+		rc.timeout, err = time.ParseDuration(settings.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid check timeout: %v", err)
+		}
+	}
+
+	concurrency := settings.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultCheckConcurrency
+	}
+	rc.slots = make(chan struct{}, concurrency)
+
+	return rc, nil
+}
+
+// Check opens a TCP connection to the server of c and reads its SSH banner. Connections behind jump hosts are checked through their first jump host, as their server cannot be reached directly.
+//
+// If the checker authenticates, it then logs in through the whole chain of jump hosts without prompting for anything: unknown host keys are refused, and keys needing a passphrase are skipped. Passwords in a locked vault cannot be used, so these connections are only checked for their banner.
+//
+// It blocks until a check slot is available.
+func (rc *ReachabilityChecker) Check(cm ConnectionManager, c Connection) Reachability {
+	rc.slots <- struct{}{}
+	defer func() { <-rc.slots }()
+
+	jumps, err := cm.JumpChain(c)
+	if err != nil {
+		return Reachability{Status: ReachabilityDown, Err: err}
+	}
+
+	target := c
+	if len(jumps) > 0 {
+		target = jumps[0]
+	}
+
+	result := rc.readBanner(target)
+	if target.ID != c.ID {
+		result.Via = target.Name()
+	}
+
+	if result.Status != ReachabilityUp || !rc.authenticate || cm.needsVaultUnlock(append(jumps, c)) {
+		return result
+	}
+
+	client, err := rc.dial(cm, c)
+	if err != nil {
+		result.Status = ReachabilityAuthFailed
+		result.Err = err
+		return result
+	}
+	defer client.Close()
+
+	result.Status = ReachabilityAuthenticated
+	result.ServerVersion = string(client.ServerVersion())
+	result.Via = ""
+
+	return result
+}
+
+// readBanner connects to the server of c, and reads the version it announces.
+func (rc *ReachabilityChecker) readBanner(c Connection) Reachability {
+	start := time.Now()
+
+	conn, err := net.DialTimeout("tcp", c.Address(), rc.timeout)
+	if err != nil {
+		return Reachability{Status: ReachabilityDown, Err: err}
+	}
+	defer conn.Close()
+
+	result := Reachability{Latency: time.Since(start)}
+	conn.SetDeadline(time.Now().Add(rc.timeout))
+
+	reader := bufio.NewReader(conn)
+	for range maxBannerLines {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			result.Status = ReachabilityDown
+			result.Err = fmt.Errorf("no ssh banner: %w", err)
+			return result
+		}
+
+		if strings.HasPrefix(line, "SSH-") {
+			result.Status = ReachabilityUp
+			result.ServerVersion = strings.TrimSpace(line)
+			return result
+		}
+	}
+
+	result.Status = ReachabilityDown
+	result.Err = fmt.Errorf("no ssh banner")
+	return result
+}
+
+// dial logs in to c without prompting, giving up after the timeout of the checker.
+func (rc *ReachabilityChecker) dial(cm ConnectionManager, c Connection) (*ssh.Client, error) {
+	type dialResult struct {
+		client *ssh.Client
+		err    error
+	}
+
+	done := make(chan dialResult, 1)
+	go func() {
+		client, err := cm.dial(c, false)
+		done <- dialResult{client, err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.client, result.err
+	case <-time.After(rc.timeout):
+		// the client is closed once the dial finishes, as nobody is waiting for it anymore
+		go func() {
+			if result := <-done; result.client != nil {
+				result.client.Close()
+			}
+		}()
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+// needsVaultUnlock reports whether any of the connections reads its password from the vault while it is locked.
+func (cm ConnectionManager) needsVaultUnlock(connections []Connection) bool {
+	for _, conn := range connections {
+		if !conn.IsPassword || cm.SecretBackend(conn) != SecretBackendVault {
+			continue
+		}
+
+		vault, err := OpenVault()
+		if err != nil || vault.Locked() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkErrorReason shortens the error of a failed check to its cause, e.g. "connection refused" instead of the whole dial error.
+func checkErrorReason(err error) string {
+	if err == nil {
+		return "unknown error"
+	}
+
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return "timed out"
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return strings.TrimPrefix(opErr.Err.Error(), "connect: ")
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,315 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Names of the available secret backends.
+const (
+	SecretBackendPass    = "pass"
+	SecretBackendGopass  = "gopass"
+	SecretBackendVault   = "vault"
+	SecretBackendEnv     = "env"
+	SecretBackendCommand = "command"
+)
+
+// SecretEnvPrefix is the prefix of the environment variables read by the env backend.
+const SecretEnvPrefix = "SSH_MANAGER_SECRET_"
+
+// errReadOnlySecretStore is returned when storing or removing secrets from a backend that can only look them up.
+var errReadOnlySecretStore = errors.New("secret backend is read-only")
+
+// SecretStore stores the passwords of connections, indexed by a key identifying the connection.
+type SecretStore interface {
+	Store(key string, secret string) error
+	Remove(key string) error
+	Get(key string) (string, error)
+}
+
+// SecretSettings configures where secrets are stored. Connections use Backend unless they set their own SecretBackend.
+type SecretSettings struct {
+	// Backend is the name of the default secret backend, pass if empty
+	Backend string
+	// Command is run through the shell by the command backend to look up a secret, with {key} replaced by the connection key. The secret is read from its output.
+	Command string
+	// VaultIdleTimeout is how long the vault stays unlocked without being used, as a duration such as "15m"
+	VaultIdleTimeout string
+}
+
+// SecretStore returns the secret store used by the given connection. The vault is unlocked with VaultPassphrasePrompt when needed.
+func (cm ConnectionManager) SecretStore(c Connection) (SecretStore, error) {
+	return newSecretStore(cm.SecretBackend(c), cm.Secrets, VaultPassphrasePrompt)
+}
+
+// SecretBackend returns the name of the secret backend used by the given connection.
+func (cm ConnectionManager) SecretBackend(c Connection) string {
+	backend := c.SecretBackend
+	if backend == "" {
+		backend = cm.Secrets.Backend
+	}
+
+	if backend == "" {
+		return SecretBackendPass
+	}
+
+	return backend
+}
+
+// UsesVault reports whether any stored password is kept in the vault.
+func (cm ConnectionManager) UsesVault() bool {
+	for _, conn := range cm.Connections {
+		if conn.IsPassword && cm.SecretBackend(conn) == SecretBackendVault {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MigrateSecrets moves the passwords of all connections to the given backend, which becomes the default one. Passwords are removed from their previous store if remove is set, once the new configuration has been saved.
+//
+// It returns the connections whose password has been moved.
+func (cm *ConnectionManager) MigrateSecrets(backend string, remove bool) ([]Connection, error) {
+	target, err := newSecretStore(backend, cm.Secrets, VaultPassphrasePrompt)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrated []Connection
+	var sources []SecretStore
+	for _, conn := range cm.Connections {
+		// passwords still stored under their legacy key are moved to their ID, even when they are already in the right backend
+		if !conn.IsPassword || (cm.SecretBackend(conn) == backend && conn.LegacySecretKey == "") {
+			continue
+		}
+
+		source, err := cm.SecretStore(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		password, err := source.Get(conn.secretKey())
+		if err != nil {
+			return nil, err
+		}
+
+		if err := target.Store(conn.ID, password); err != nil {
+			return nil, err
+		}
+
+		migrated = append(migrated, conn)
+		sources = append(sources, source)
+	}
+
+	cm.Secrets.Backend = backend
+	for i := range cm.Connections {
+		cm.Connections[i].SecretBackend = ""
+		if cm.Connections[i].IsPassword {
+			cm.Connections[i].LegacySecretKey = ""
+		}
+	}
+
+	if err := cm.SaveToDisk(); err != nil {
+		return nil, fmt.Errorf("failed to save to disk after migrating secrets: %w", err)
+	}
+
+	if remove {
+		removed := map[string]bool{}
+		for i, conn := range migrated {
+			// legacy keys can be shared by several connections
+			if removed[conn.secretKey()] {
+				continue
+			}
+			removed[conn.secretKey()] = true
+
+			if err := sources[i].Remove(conn.secretKey()); err != nil {
+				return migrated, err
+			}
+		}
+	}
+
+	return migrated, nil
+}
+
+// newSecretStore returns the secret store for the backend with the given name. The vault is unlocked with prompt when needed, which may be nil to never prompt.
+func newSecretStore(backend string, settings SecretSettings, prompt func() (string, error)) (SecretStore, error) {
+	switch backend {
+	case "", SecretBackendPass:
+		return passStore{command: "pass"}, nil
+	case SecretBackendGopass:
+		return passStore{command: "gopass"}, nil
+	case SecretBackendVault:
+		vault, err := OpenVault()
+		if err != nil {
+			return nil, err
+		}
+		vault.prompt = prompt
+
+		if settings.VaultIdleTimeout != "" {
+			vault.IdleTimeout, err = time.ParseDuration(settings.VaultIdleTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("invalid vault idle timeout: %v", err)
+			}
+		}
+
+		return vault, nil
+	case SecretBackendEnv:
+		return envStore{}, nil
+	case SecretBackendCommand:
+		if settings.Command == "" {
+			return nil, fmt.Errorf("the command secret backend requires a command to be set in the secret settings")
+		}
+		return commandStore{command: settings.Command}, nil
+	}
+
+	return nil, fmt.Errorf("unknown secret backend %q", backend)
+}
+
+// secretKey returns the key under which the password of the connection is stored: its ID, or the user@host key used before connections had one.
+func (c Connection) secretKey() string {
+	if c.LegacySecretKey != "" {
+		return c.LegacySecretKey
+	}
+
+	return c.ID
+}
+
+// StorePassword stores the password of the connection in its secret store.
+func (cm ConnectionManager) StorePassword(c Connection, password string) error {
+	store, err := cm.SecretStore(c)
+	if err != nil {
+		return err
+	}
+
+	return store.Store(c.secretKey(), password)
+}
+
+// RemovePassword removes the password of the connection from its secret store.
+func (cm ConnectionManager) RemovePassword(c Connection) error {
+	store, err := cm.SecretStore(c)
+	if err != nil {
+		return err
+	}
+
+	return store.Remove(c.secretKey())
+}
+
+// Password returns the password of the connection from its secret store.
+func (cm ConnectionManager) Password(c Connection) (string, error) {
+	store, err := cm.SecretStore(c)
+	if err != nil {
+		return "", err
+	}
+
+	return store.Get(c.secretKey())
+}
+
+// passStore stores secrets with pass, or any password manager sharing its command line interface such as gopass.
+type passStore struct {
+	command string
+}
+
+func (ps passStore) Store(key string, secret string) error {
+	cmd := exec.Command(ps.command, "insert", "-e", "-f", key)
+	cmd.Stdin = strings.NewReader(secret)
+
+	err := cmd.Run()
+
+	if err != nil {
+		return fmt.Errorf("failed to store password with %s: %v", ps.command, err)
+	}
+
+	return nil
+}
+
+func (ps passStore) Remove(key string) error {
+	cmd := exec.Command(ps.command, "rm", "-f", key)
+
+	err := cmd.Run()
+
+	if err != nil {
+		return fmt.Errorf("failed to delete password with %s: %v", ps.command, err)
+	}
+
+	return nil
+}
+
+func (ps passStore) Get(key string) (string, error) {
+	cmd := exec.Command(ps.command, "show", key)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read password with %s: %v", ps.command, err)
+	}
+
+	// by convention, the password is on the first line, and the following ones hold metadata
+	password, _, _ := strings.Cut(string(out), "\n")
+
+	return password, nil
+}
+
+// envStore looks up secrets from environment variables, named after the key prefixed by SecretEnvPrefix.
+type envStore struct{}
+
+func (envStore) Store(string, string) error {
+	return errReadOnlySecretStore
+}
+
+func (envStore) Remove(string) error {
+	return errReadOnlySecretStore
+}
+
+func (envStore) Get(key string) (string, error) {
+	name := SecretEnvVar(key)
+
+	password, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("failed to read password: %s is not set", name)
+	}
+
+	return password, nil
+}
+
+// SecretEnvVar returns the environment variable the env backend reads the secret with the given key from. Characters that are not letters or digits are replaced by underscores, and letters are uppercased, e.g. SSH_MANAGER_SECRET_9F86D081884C7D65 for the connection with ID 9f86d081884c7d65.
+func SecretEnvVar(key string) string {
+	return SecretEnvPrefix + strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, key)
+}
+
+// commandStore looks up secrets by running a command, such as a password manager's CLI.
+type commandStore struct {
+	command string
+}
+
+func (commandStore) Store(string, string) error {
+	return errReadOnlySecretStore
+}
+
+func (commandStore) Remove(string) error {
+	return errReadOnlySecretStore
+}
+
+func (cs commandStore) Get(key string) (string, error) {
+	// the key is quoted, as it comes from user input
+	quotedKey := "'" + strings.ReplaceAll(key, "'", `'\''`) + "'"
+	cmd := exec.Command("sh", "-c", strings.ReplaceAll(cs.command, "{key}", quotedKey))
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read password with command: %v", err)
+	}
+
+	password, _, _ := strings.Cut(string(out), "\n")
+
+	return password, nil
+}
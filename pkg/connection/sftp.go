@@ -0,0 +1,233 @@
+package connection
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// transferBufferSize is the size of the chunks files are copied in by CopyFile, which is also how often progress is reported. It is large enough for SFTP to send several packets at once.
+const transferBufferSize = 1024 * 1024
+
+// FileSystem is a file system browsed and changed from ssh-manager, either the local one or the one of a host over SFTP. Paths are absolute, and use the separator of the file system.
+type FileSystem interface {
+	// Getwd returns the directory browsing starts in
+	Getwd() (string, error)
+	ReadDir(dir string) ([]os.FileInfo, error)
+	Stat(name string) (os.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+	// Create creates or truncates the file
+	Create(name string) (io.WriteCloser, error)
+	Rename(from, to string) error
+	// RemoveAll removes the file, or the directory and everything it contains
+	RemoveAll(name string) error
+	Mkdir(name string) error
+	Join(elem ...string) string
+	Dir(name string) string
+}
+
+// LocalFileSystem is the file system of the machine ssh-manager runs on.
+type LocalFileSystem struct{}
+
+func (LocalFileSystem) Getwd() (string, error) {
+	return os.Getwd()
+}
+
+func (LocalFileSystem) ReadDir(dir string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		// entries removed while being listed are skipped
+		if info, err := entry.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+
+	return infos, nil
+}
+
+func (LocalFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (LocalFileSystem) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (LocalFileSystem) Create(name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (LocalFileSystem) Rename(from, to string) error {
+	return os.Rename(from, to)
+}
+
+func (LocalFileSystem) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (LocalFileSystem) Mkdir(name string) error {
+	return os.Mkdir(name, 0o755)
+}
+
+func (LocalFileSystem) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (LocalFileSystem) Dir(name string) string {
+	return filepath.Dir(name)
+}
+
+// SFTPFileSystem is the file system of a host, accessed over SFTP. It keeps the connection it was opened on alive until it is closed.
+type SFTPFileSystem struct {
+	client *sftp.Client
+	conn   *ssh.Client
+	done   chan struct{}
+}
+
+// OpenSFTP opens an SFTP session on the connection, going through its jump hosts first and prompting for what is needed to authenticate, as described in Dial. Keepalives are sent as for interactive sessions.
+//
+// It returns the file system of the host, which has to be closed once done with it.
+func (cm ConnectionManager) OpenSFTP(c Connection) (*SFTPFileSystem, error) {
+	conn, err := cm.Dial(c)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to start sftp session: %v", err)
+	}
+
+	fs := &SFTPFileSystem{client: client, conn: conn, done: make(chan struct{})}
+
+	// a lost connection makes the next operation fail, there is nothing else to interrupt
+	if interval := c.KeepaliveInterval(); interval > 0 {
+		go keepAlive(conn, interval, c.KeepaliveCountMax(), fs.done, make(chan struct{}))
+	}
+
+	return fs, nil
+}
+
+// Close ends the SFTP session and closes its connection.
+func (fs *SFTPFileSystem) Close() error {
+	close(fs.done)
+	fs.client.Close()
+
+	return fs.conn.Close()
+}
+
+// Getwd returns the directory the SFTP session starts in, usually the home directory of the user.
+func (fs *SFTPFileSystem) Getwd() (string, error) {
+	return fs.client.Getwd()
+}
+
+func (fs *SFTPFileSystem) ReadDir(dir string) ([]os.FileInfo, error) {
+	return fs.client.ReadDir(dir)
+}
+
+func (fs *SFTPFileSystem) Stat(name string) (os.FileInfo, error) {
+	return fs.client.Stat(name)
+}
+
+func (fs *SFTPFileSystem) Open(name string) (io.ReadCloser, error) {
+	return fs.client.Open(name)
+}
+
+func (fs *SFTPFileSystem) Create(name string) (io.WriteCloser, error) {
+	return fs.client.Create(name)
+}
+
+// Rename renames the file, replacing the destination if the server supports it, as os.Rename does.
+func (fs *SFTPFileSystem) Rename(from, to string) error {
+	if _, ok := fs.client.HasExtension("posix-rename@openssh.com"); ok {
+		return fs.client.PosixRename(from, to)
+	}
+
+	return fs.client.Rename(from, to)
+}
+
+func (fs *SFTPFileSystem) RemoveAll(name string) error {
+	return fs.client.RemoveAll(name)
+}
+
+func (fs *SFTPFileSystem) Mkdir(name string) error {
+	return fs.client.Mkdir(name)
+}
+
+func (fs *SFTPFileSystem) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func (fs *SFTPFileSystem) Dir(name string) string {
+	return path.Dir(name)
+}
+
+// CopyFile copies a file from one file system to another, e.g. to upload or download it over SFTP. progress is called with the number of bytes copied so far after every chunk, and may be nil. Directories cannot be copied.
+//
+// It returns the number of bytes copied.
+func CopyFile(dst FileSystem, dstName string, src FileSystem, srcName string, progress func(copied int64)) (int64, error) {
+	info, err := src.Stat(srcName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %v", srcName, err)
+	}
+
+	if info.IsDir() {
+		return 0, fmt.Errorf("%s is a directory, only files can be copied", srcName)
+	}
+
+	r, err := src.Open(srcName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %v", srcName, err)
+	}
+
+	defer r.Close()
+
+	w, err := dst.Create(dstName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %v", dstName, err)
+	}
+
+	copied, err := io.CopyBuffer(&progressWriter{w: w, progress: progress}, r, make([]byte, transferBufferSize))
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return copied, fmt.Errorf("failed to copy %s: %v", srcName, err)
+	}
+
+	return copied, nil
+}
+
+// progressWriter reports the number of bytes written so far after every write.
+type progressWriter struct {
+	w        io.Writer
+	written  int64
+	progress func(written int64)
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.written += int64(n)
+
+	if pw.progress != nil {
+		pw.progress(pw.written)
+	}
+
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+
+	return n, err
+}
@@ -0,0 +1,137 @@
+package connection
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// SOCKS5 protocol values, see RFC 1928.
+const (
+	socksVersion         = 0x05
+	socksNoAuth          = 0x00
+	socksNoAcceptable    = 0xff
+	socksConnect         = 0x01
+	socksAddrIPv4        = 0x01
+	socksAddrDomain      = 0x03
+	socksAddrIPv6        = 0x04
+	socksSucceeded       = 0x00
+	socksGeneralFailure  = 0x01
+	socksCmdUnsupported  = 0x07
+	socksAddrUnsupported = 0x08
+)
+
+// errUnsupportedSOCKS is returned when a SOCKS client requests something other than a SOCKS5 CONNECT without authentication.
+var errUnsupportedSOCKS = errors.New("unsupported socks request")
+
+// serveSOCKS5 performs the server side of a SOCKS5 handshake on conn, and opens the requested connection with dial. Only the CONNECT command without authentication is supported, which is what ssh -D provides.
+//
+// It returns the connection to the requested address, ready to be piped to conn.
+func serveSOCKS5(conn net.Conn, dial func(address string) (net.Conn, error)) (net.Conn, error) {
+	// greeting: version, number of methods, methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+
+	if header[0] != socksVersion {
+		return nil, errUnsupportedSOCKS
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return nil, err
+	}
+
+	hasNoAuth := false
+	for _, method := range methods {
+		if method == socksNoAuth {
+			hasNoAuth = true
+		}
+	}
+
+	if !hasNoAuth {
+		conn.Write([]byte{socksVersion, socksNoAcceptable})
+		return nil, errUnsupportedSOCKS
+	}
+
+	if _, err := conn.Write([]byte{socksVersion, socksNoAuth}); err != nil {
+		return nil, err
+	}
+
+	// request: version, command, reserved, address type, address, port
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return nil, err
+	}
+
+	if request[1] != socksConnect {
+		writeSOCKSReply(conn, socksCmdUnsupported)
+		return nil, errUnsupportedSOCKS
+	}
+
+	host, err := readSOCKSAddress(conn, request[3])
+	if err != nil {
+		writeSOCKSReply(conn, socksAddrUnsupported)
+		return nil, err
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return nil, err
+	}
+
+	target, err := dial(net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		writeSOCKSReply(conn, socksGeneralFailure)
+		return nil, err
+	}
+
+	if err := writeSOCKSReply(conn, socksSucceeded); err != nil {
+		target.Close()
+		return nil, err
+	}
+
+	return target, nil
+}
+
+// readSOCKSAddress reads the destination address of a SOCKS5 request, of the given address type.
+func readSOCKSAddress(conn net.Conn, addressType byte) (string, error) {
+	switch addressType {
+	case socksAddrIPv4, socksAddrIPv6:
+		size := net.IPv4len
+		if addressType == socksAddrIPv6 {
+			size = net.IPv6len
+		}
+
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+
+		return net.IP(ip).String(), nil
+	case socksAddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+
+		return string(domain), nil
+	}
+
+	return "", fmt.Errorf("%w: address type %d", errUnsupportedSOCKS, addressType)
+}
+
+// writeSOCKSReply sends a reply to a SOCKS5 request. The bound address is always reported as 0.0.0.0:0, as the connection is made from the server.
+func writeSOCKSReply(conn net.Conn, status byte) error {
+	_, err := conn.Write([]byte{socksVersion, status, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
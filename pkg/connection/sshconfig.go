@@ -0,0 +1,535 @@
+package connection
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth limits nested Include directives, like OpenSSH does, so that include loops are detected.
+const maxIncludeDepth = 16
+
+// sshConfigOption is a single keyword of an OpenSSH config file, with its arguments.
+type sshConfigOption struct {
+	keyword string
+	args    []string
+}
+
+// sshConfigBlock is a Host block of an OpenSSH config file. Options before the first Host block are stored in a block matching every host.
+type sshConfigBlock struct {
+	patterns []string
+	options  []sshConfigOption
+}
+
+// ImportResult describes what happened when importing connections into a ConnectionManager.
+type ImportResult struct {
+	Added      []Connection
+	Duplicates []Connection
+	// Invalid holds the connections that were skipped because they failed validation, the reasons being reported in Warnings
+	Invalid  []Connection
+	Warnings []string
+}
+
+// DefaultSSHConfigPath returns the path to the user's OpenSSH config file.
+func DefaultSSHConfigPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to get user home directory: %v", err)
+	}
+
+	return filepath.Join(homeDir, ".ssh", "config"), nil
+}
+
+// ImportSSHConfig parses the OpenSSH config file at path, and adds the hosts it defines to the manager. Hosts that are already stored are skipped.
+func (cm *ConnectionManager) ImportSSHConfig(path string) (ImportResult, error) {
+	connections, warnings, err := ParseSSHConfig(path)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	result := cm.Import(connections)
+	result.Warnings = append(warnings, result.Warnings...)
+
+	if len(result.Added) == 0 {
+		return result, nil
+	}
+
+	if err := cm.SaveToDisk(); err != nil {
+		return result, fmt.Errorf("failed to save to disk after importing connections: %w", err)
+	}
+
+	return result, nil
+}
+
+// Import adds the given connections to the manager, skipping the ones that are already stored. It does not save to disk.
+//
+// Imported connections are given an ID if they do not have one, and jump hosts referencing a skipped connection are pointed to the stored one. Aliases that are already used get a number appended to them. Connections that are not valid, e.g. with a host still holding tokens such as %r that cannot be translated, are skipped with a warning, as are the connections going through them as jump hosts.
+func (cm *ConnectionManager) Import(connections []Connection) ImportResult {
+	var result ImportResult
+	// IDs of the skipped connections, mapped to the IDs of the stored ones
+	replacedIDs := map[string]string{}
+
+	for _, conn := range connections {
+		if stored, ok := cm.findByDestination(conn.Destination()); ok {
+			if conn.ID != "" {
+				replacedIDs[conn.ID] = stored.ID
+			}
+			result.Duplicates = append(result.Duplicates, conn)
+			continue
+		}
+
+		if conn.Port == 0 {
+			conn.Port = DefaultPort
+		}
+
+		if conn.ID == "" {
+			conn.ID = newConnectionID()
+		}
+
+		if conn.Alias != "" {
+			conn.Alias = cm.uniqueAlias(conn.Alias)
+		}
+
+		err := conn.Validate()
+		if err == nil {
+			err = cm.ValidateAlias(conn)
+		}
+
+		if err != nil {
+			result.Invalid = append(result.Invalid, conn)
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s: skipped, %v", conn.Name(), err))
+			continue
+		}
+
+		cm.Connections = append(cm.Connections, conn)
+		result.Added = append(result.Added, conn)
+	}
+
+	// connections jumping through a skipped connection could not be reached, so they are skipped as well, and so on for the ones jumping through them
+	skipped := map[string]string{}
+	for _, conn := range result.Invalid {
+		skipped[conn.ID] = conn.Name()
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, conn := range result.Added {
+			if _, ok := skipped[conn.ID]; ok {
+				continue
+			}
+
+			for _, id := range conn.JumpHosts {
+				if name, ok := skipped[id]; ok {
+					skipped[conn.ID] = conn.Name()
+					result.Invalid = append(result.Invalid, conn)
+					result.Warnings = append(result.Warnings, fmt.Sprintf("%s: skipped, its jump host %s was skipped", conn.Name(), name))
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	isSkipped := func(conn Connection) bool {
+		_, ok := skipped[conn.ID]
+		return ok
+	}
+	result.Added = slices.DeleteFunc(result.Added, isSkipped)
+	cm.Connections = slices.DeleteFunc(cm.Connections, isSkipped)
+
+	for i := range result.Added {
+		jumpHosts := slices.Clone(result.Added[i].JumpHosts)
+		for j, id := range jumpHosts {
+			if storedID, ok := replacedIDs[id]; ok {
+				jumpHosts[j] = storedID
+			}
+		}
+
+		result.Added[i].JumpHosts = jumpHosts
+		cm.Connections[len(cm.Connections)-len(result.Added)+i].JumpHosts = jumpHosts
+	}
+
+	return result
+}
+
+// ParseSSHConfig parses the OpenSSH config file at path, following Include directives, and returns a connection for every host it defines.
+//
+// Host blocks using wildcards are not imported themselves, but are applied as defaults to the hosts they match. Match blocks are skipped, and reported in the returned warnings.
+func ParseSSHConfig(path string) ([]Connection, []string, error) {
+	parser := sshConfigParser{
+		blocks: []*sshConfigBlock{{patterns: []string{"*"}}},
+	}
+
+	if err := parser.parseFile(path, 0); err != nil {
+		return nil, nil, err
+	}
+
+	defaultUser := ""
+	if u, err := user.Current(); err == nil {
+		defaultUser = u.Username
+	}
+
+	// hosts are collected first, so that jump hosts can reference aliases defined anywhere in the file
+	var aliases []string
+	seen := map[string]bool{}
+	for _, block := range parser.blocks {
+		for _, pattern := range block.patterns {
+			if isConcreteHost(pattern) && !seen[pattern] {
+				seen[pattern] = true
+				aliases = append(aliases, pattern)
+			}
+		}
+	}
+
+	byAlias := map[string]Connection{}
+	for _, alias := range aliases {
+		conn, warnings := parser.resolve(alias, defaultUser)
+		parser.warnings = append(parser.warnings, warnings...)
+		conn.ID = newConnectionID()
+		conn.Alias = alias
+		byAlias[alias] = conn
+	}
+
+	var connections []Connection
+	var jumpConnections []Connection
+	for _, alias := range aliases {
+		conn := byAlias[alias]
+
+		// jump hosts are stored as references to other connections, so the ones that are not defined in the file are imported as well
+		for i, jump := range conn.JumpHosts {
+			jumpConn, ok := byAlias[jump]
+			if !ok {
+				var err error
+				jumpConn, err = parseJumpHost(jump, defaultUser)
+				if err != nil {
+					parser.warnings = append(parser.warnings, fmt.Sprintf("%s: %v", alias, err))
+					continue
+				}
+				jumpConn.ID = newConnectionID()
+				jumpConnections = append(jumpConnections, jumpConn)
+			}
+			conn.JumpHosts[i] = jumpConn.ID
+		}
+
+		connections = append(connections, conn)
+	}
+
+	return append(connections, jumpConnections...), parser.warnings, nil
+}
+
+type sshConfigParser struct {
+	blocks   []*sshConfigBlock
+	warnings []string
+	// skipping is set while inside a Match block
+	skipping bool
+}
+
+// parseFile reads the config file at path, appending its blocks to the parser.
+func (p *sshConfigParser) parseFile(path string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("too many nested includes in %s", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open ssh config: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+
+		keyword, args, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+
+		switch keyword {
+		case "":
+			continue
+		case "host":
+			p.skipping = false
+			p.blocks = append(p.blocks, &sshConfigBlock{patterns: args})
+		case "match":
+			p.skipping = true
+			p.warnings = append(p.warnings, fmt.Sprintf("%s:%d: Match blocks are not supported, skipping", path, lineNum))
+		case "include":
+			for _, pattern := range args {
+				if err := p.include(pattern, depth); err != nil {
+					return fmt.Errorf("%s:%d: %v", path, lineNum, err)
+				}
+			}
+		default:
+			if p.skipping {
+				continue
+			}
+
+			current := p.blocks[len(p.blocks)-1]
+			current.options = append(current.options, sshConfigOption{keyword: keyword, args: args})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read ssh config: %w", err)
+	}
+
+	return nil
+}
+
+// include parses the files matching an Include pattern. Relative paths are relative to ~/.ssh.
+func (p *sshConfigParser) include(pattern string, depth int) error {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("unable to get user home directory: %v", err)
+	}
+
+	pattern = expandHome(pattern, homeDir)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(homeDir, ".ssh", pattern)
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid include %s: %v", pattern, err)
+	}
+
+	for _, match := range matches {
+		if err := p.parseFile(match, depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolve builds the connection for a host alias, applying the options of every block matching it. As with OpenSSH, the first value obtained for an option is used, except for options that can be given multiple times.
+func (p *sshConfigParser) resolve(alias string, defaultUser string) (Connection, []string) {
+	var warnings []string
+	conn := Connection{}
+	set := map[string]bool{}
+
+	for _, block := range p.blocks {
+		if !matchHostPatterns(block.patterns, alias) {
+			continue
+		}
+
+		for _, option := range block.options {
+			if len(option.args) == 0 {
+				continue
+			}
+
+			arg := option.args[0]
+
+			switch option.keyword {
+			case "identityfile":
+				conn.IdentityFiles = append(conn.IdentityFiles, arg)
+				continue
+			case "localforward", "remoteforward", "dynamicforward":
+				flag := strings.ToUpper(option.keyword[:1])
+				forward, err := ParseForward(flag + " " + strings.Join(option.args, ":"))
+				if err != nil {
+					warnings = append(warnings, fmt.Sprintf("%s: %v", alias, err))
+					continue
+				}
+				conn.Forwards = append(conn.Forwards, forward)
+				continue
+			}
+
+			if set[option.keyword] {
+				continue
+			}
+			set[option.keyword] = true
+
+			switch option.keyword {
+			case "hostname":
+				conn.Host = strings.NewReplacer("%h", alias, "%%", "%").Replace(arg)
+			case "user":
+				conn.Username = arg
+			case "port":
+				port, err := strconv.Atoi(arg)
+				if err != nil {
+					warnings = append(warnings, fmt.Sprintf("%s: invalid port %s", alias, arg))
+					continue
+				}
+				conn.Port = port
+			case "serveraliveinterval":
+				interval, err := strconv.Atoi(arg)
+				if err != nil || interval < 0 {
+					warnings = append(warnings, fmt.Sprintf("%s: invalid ServerAliveInterval %s", alias, arg))
+					continue
+				}
+				// keepalives are disabled with 0 in ssh config files, which means the default here
+				if interval == 0 {
+					interval = -1
+				}
+				conn.ServerAliveInterval = interval
+			case "serveralivecountmax":
+				count, err := strconv.Atoi(arg)
+				if err != nil || count < 1 {
+					warnings = append(warnings, fmt.Sprintf("%s: invalid ServerAliveCountMax %s", alias, arg))
+					continue
+				}
+				conn.ServerAliveCountMax = count
+			case "forwardagent":
+				conn.ForwardAgent = strings.EqualFold(arg, "yes")
+			case "identitiesonly":
+				conn.IdentitiesOnly = strings.EqualFold(arg, "yes")
+			case "identityagent":
+				if strings.EqualFold(arg, "none") {
+					conn.KeySource = KeySourceFiles
+				}
+			case "proxyjump":
+				if !strings.EqualFold(arg, "none") {
+					for _, jump := range strings.Split(arg, ",") {
+						conn.JumpHosts = append(conn.JumpHosts, strings.TrimSpace(jump))
+					}
+				}
+			}
+		}
+	}
+
+	if conn.Host == "" {
+		conn.Host = alias
+	}
+
+	if conn.Username == "" {
+		conn.Username = defaultUser
+	}
+
+	if conn.Port == 0 {
+		conn.Port = DefaultPort
+	}
+
+	return conn, warnings
+}
+
+// parseJumpHost parses a ProxyJump entry in the [user@]host[:port] format.
+func parseJumpHost(jump string, defaultUser string) (Connection, error) {
+	conn := Connection{Username: defaultUser, Port: DefaultPort}
+
+	if username, hostPort, ok := strings.Cut(jump, "@"); ok {
+		conn.Username = username
+		jump = hostPort
+	}
+
+	host, port, err := net.SplitHostPort(jump)
+	if err != nil {
+		conn.Host = strings.Trim(jump, "[]")
+		return conn, nil
+	}
+
+	conn.Host = host
+	conn.Port, err = strconv.Atoi(port)
+	if err != nil {
+		return Connection{}, fmt.Errorf("invalid jump host port %s", port)
+	}
+
+	return conn, nil
+}
+
+// splitSSHConfigLine splits a config line into its lowercased keyword and its arguments. Keywords can be separated from their arguments by whitespace or an equal sign, and arguments can be quoted.
+func splitSSHConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+
+	keyword, rest := line, ""
+	if i := strings.IndexAny(line, " \t="); i >= 0 {
+		keyword, rest = line[:i], line[i:]
+	}
+	rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest), "="))
+
+	var args []string
+	var current strings.Builder
+	inQuotes, hasArg := false, false
+
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if hasArg {
+				args = append(args, current.String())
+				current.Reset()
+				hasArg = false
+			}
+		default:
+			current.WriteRune(r)
+			hasArg = true
+		}
+	}
+
+	if inQuotes {
+		return "", nil, fmt.Errorf("unterminated quote")
+	}
+
+	if hasArg {
+		args = append(args, current.String())
+	}
+
+	return strings.ToLower(keyword), args, nil
+}
+
+// isConcreteHost reports whether a Host pattern names a single host, rather than being a wildcard or a negation.
+func isConcreteHost(pattern string) bool {
+	return !strings.ContainsAny(pattern, "*?!")
+}
+
+// matchHostPatterns reports whether the alias matches a list of Host patterns: it needs to match at least one pattern, and none of the negated ones.
+func matchHostPatterns(patterns []string, alias string) bool {
+	matched := false
+
+	for _, pattern := range patterns {
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			if matchWildcard(negated, alias) {
+				return false
+			}
+			continue
+		}
+
+		if matchWildcard(pattern, alias) {
+			matched = true
+		}
+	}
+
+	return matched
+}
+
+// matchWildcard matches a string against a pattern where * matches any sequence of characters and ? a single one, case-insensitively like OpenSSH.
+func matchWildcard(pattern string, s string) bool {
+	pattern, s = strings.ToLower(pattern), strings.ToLower(s)
+
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := 0; i <= len(s); i++ {
+				if matchWildcard(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+
+		pattern, s = pattern[1:], s[1:]
+	}
+
+	return len(s) == 0
+}
@@ -0,0 +1,280 @@
+package connection
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseSSHConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		// files are written in ~/.ssh, the config being read from ~/.ssh/config
+		files map[string]string
+		// want holds the imported connections without their ID, their jump hosts being given by name
+		want         []Connection
+		wantWarnings int
+	}{
+		{
+			name: "host options",
+			files: map[string]string{"config": `
+Host web
+  HostName web.example.com
+  User deploy
+  Port 2222
+  IdentityFile ~/.ssh/id_web
+  IdentityFile ~/.ssh/id_backup
+  IdentitiesOnly yes
+  ForwardAgent yes
+  IdentityAgent none
+  ServerAliveInterval 15
+  ServerAliveCountMax 5
+`},
+			want: []Connection{{
+				Alias:               "web",
+				Username:            "deploy",
+				Host:                "web.example.com",
+				Port:                2222,
+				IdentityFiles:       []string{"~/.ssh/id_web", "~/.ssh/id_backup"},
+				IdentitiesOnly:      true,
+				ForwardAgent:        true,
+				KeySource:           KeySourceFiles,
+				ServerAliveInterval: 15,
+				ServerAliveCountMax: 5,
+			}},
+		},
+		{
+			name: "wildcard defaults apply after host options",
+			files: map[string]string{"config": `
+Host db
+  User postgres
+
+Host *
+  User admin
+  Port 2200
+`},
+			want: []Connection{{Alias: "db", Username: "postgres", Host: "db", Port: 2200}},
+		},
+		{
+			name: "equal signs, quotes and hostname tokens",
+			files: map[string]string{"config": `
+Host app
+  HostName=%h.internal
+  User = ops
+  IdentityFile "~/my keys/id_app"
+`},
+			want: []Connection{{Alias: "app", Username: "ops", Host: "app.internal", Port: DefaultPort, IdentityFiles: []string{"~/my keys/id_app"}}},
+		},
+		{
+			name: "disabled keepalives",
+			files: map[string]string{"config": `
+Host quiet
+  User me
+  ServerAliveInterval 0
+`},
+			want: []Connection{{Alias: "quiet", Username: "me", Host: "quiet", Port: DefaultPort, ServerAliveInterval: -1}},
+		},
+		{
+			name: "forwards",
+			files: map[string]string{"config": `
+Host tunnel
+  User me
+  LocalForward 8080 localhost:80
+  RemoteForward 9000 localhost:3000
+  DynamicForward 1080
+  LocalForward nonsense
+`},
+			want: []Connection{{
+				Alias:    "tunnel",
+				Username: "me",
+				Host:     "tunnel",
+				Port:     DefaultPort,
+				Forwards: []Forward{
+					{Type: ForwardLocal, Bind: "8080", Target: "localhost:80"},
+					{Type: ForwardRemote, Bind: "9000", Target: "localhost:3000"},
+					{Type: ForwardDynamic, Bind: "1080"},
+				},
+			}},
+			wantWarnings: 1,
+		},
+		{
+			name: "jump hosts defined in the file or not",
+			files: map[string]string{"config": `
+Host inner
+  User app
+  ProxyJump bastion,ops@gateway.example.com:2222
+
+Host bastion
+  User jump
+`},
+			want: []Connection{
+				{Alias: "inner", Username: "app", Host: "inner", Port: DefaultPort, JumpHosts: []string{"bastion", "ops@gateway.example.com:2222"}},
+				{Alias: "bastion", Username: "jump", Host: "bastion", Port: DefaultPort},
+				{Username: "ops", Host: "gateway.example.com", Port: 2222},
+			},
+		},
+		{
+			name: "match blocks are skipped",
+			files: map[string]string{"config": `
+Host a
+  User me
+
+Match user root
+  Port 2222
+
+Host b
+  User you
+`},
+			want: []Connection{
+				{Alias: "a", Username: "me", Host: "a", Port: DefaultPort},
+				{Alias: "b", Username: "you", Host: "b", Port: DefaultPort},
+			},
+			wantWarnings: 1,
+		},
+		{
+			name: "includes relative to ~/.ssh",
+			files: map[string]string{
+				"config": `
+Include conf.d/*
+
+Host main
+  User me
+`,
+				"conf.d/extra": `
+Host extra
+  User other
+`,
+			},
+			want: []Connection{
+				{Alias: "extra", Username: "other", Host: "extra", Port: DefaultPort},
+				{Alias: "main", Username: "me", Host: "main", Port: DefaultPort},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+
+			for name, content := range tt.files {
+				path := filepath.Join(home, ".ssh", name)
+				if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			connections, warnings, err := ParseSSHConfig(filepath.Join(home, ".ssh", "config"))
+			if err != nil {
+				t.Fatalf("ParseSSHConfig returned an error: %v", err)
+			}
+
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("got warnings %q, want %d of them", warnings, tt.wantWarnings)
+			}
+
+			got := withJumpHostNames(connections)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got connections\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	stored := Connection{ID: "stored", Alias: "bastion", Username: "jump", Host: "bastion.example.com", Port: 22}
+
+	tests := []struct {
+		name        string
+		connections []Connection
+		wantAdded   []string
+		wantInvalid []string
+	}{
+		{
+			name: "new connections",
+			connections: []Connection{
+				{ID: "1", Alias: "web", Username: "deploy", Host: "web", Port: 22},
+				{ID: "2", Username: "deploy", Host: "db"},
+			},
+			wantAdded: []string{"web", "deploy@db:22"},
+		},
+		{
+			name: "duplicates are skipped",
+			connections: []Connection{
+				{ID: "1", Alias: "other", Username: "jump", Host: "bastion.example.com", Port: 22},
+			},
+		},
+		{
+			name: "aliases already used are numbered",
+			connections: []Connection{
+				{ID: "1", Alias: "bastion", Username: "ops", Host: "gateway", Port: 22},
+			},
+			wantAdded: []string{"bastion-2"},
+		},
+		{
+			name: "invalid connections are skipped along with the ones jumping through them",
+			connections: []Connection{
+				{ID: "1", Alias: "broken", Username: "ops", Host: "%r.example.com", Port: 22},
+				{ID: "2", Alias: "inner", Username: "app", Host: "inner", Port: 22, JumpHosts: []string{"1"}},
+				{ID: "3", Alias: "deep", Username: "app", Host: "deep", Port: 22, JumpHosts: []string{"2"}},
+				{ID: "4", Alias: "fine", Username: "app", Host: "fine", Port: 22, JumpHosts: []string{"stored"}},
+			},
+			wantAdded:   []string{"fine"},
+			wantInvalid: []string{"broken", "inner", "deep"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := ConnectionManager{Connections: []Connection{stored}}
+			result := cm.Import(tt.connections)
+
+			if got := connectionNames(result.Added); !reflect.DeepEqual(got, tt.wantAdded) {
+				t.Errorf("added %q, want %q", got, tt.wantAdded)
+			}
+
+			if got := connectionNames(result.Invalid); !reflect.DeepEqual(got, tt.wantInvalid) {
+				t.Errorf("skipped %q as invalid, want %q", got, tt.wantInvalid)
+			}
+
+			if got, want := connectionNames(cm.Connections), append([]string{"bastion"}, tt.wantAdded...); !reflect.DeepEqual(got, want) {
+				t.Errorf("stored %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// withJumpHostNames returns the connections without their ID, their jump hosts being replaced by their name when they are among them.
+func withJumpHostNames(connections []Connection) []Connection {
+	names := map[string]string{}
+	for _, conn := range connections {
+		names[conn.ID] = conn.Name()
+	}
+
+	var result []Connection
+	for _, conn := range connections {
+		conn.ID = ""
+		for i, id := range conn.JumpHosts {
+			if name, ok := names[id]; ok {
+				conn.JumpHosts[i] = name
+			}
+		}
+		result = append(result, conn)
+	}
+
+	return result
+}
+
+// connectionNames returns the names of the connections, nil if there are none.
+func connectionNames(connections []Connection) []string {
+	var names []string
+	for _, conn := range connections {
+		names = append(names, conn.Name())
+	}
+
+	return names
+}
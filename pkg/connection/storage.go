@@ -1,9 +1,13 @@
 package connection
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/pelletier/go-toml"
@@ -16,36 +20,154 @@ const (
 	StorageFilePerm  = 0600
 )
 
-// Saves the connections as a TOML file in the user config directory
-func (cm ConnectionManager) SaveToDisk() error {
+// ErrStorageModified is returned when saving connections that have been modified on disk since they were loaded, e.g. by another instance of ssh-manager. The changes can be merged with SaveMerged, or discarded by loading the connections again.
+var ErrStorageModified = errors.New("connections have been modified by another instance of ssh-manager since they were loaded")
 
-	err := ensureStorageFile()
-	if err != nil {
-		return err
-	}
+// Saves the connections as a TOML file in the user config directory. The file is locked while it is written, and ErrStorageModified is returned instead of overwriting changes made to it since it was loaded.
+func (cm *ConnectionManager) SaveToDisk() error {
+	return cm.withLockedStorage(func(storagePath string, current []byte) error {
+		if !bytes.Equal(current, cm.loaded) {
+			return ErrStorageModified
+		}
 
-	storagePath, err := storageFilePath()
-	if err != nil {
-		return err
+		return cm.save(storagePath)
+	})
+}
+
+// saveWithSecrets applies update to the connections along with the change to the secret store going with it, and saves them, holding the lock on the storage file throughout so that other instances of ssh-manager cannot save in between. A nil change does nothing.
+//
+// If the storage file has been modified since the connections were loaded, the connections are updated but not saved, and an error wrapping ErrStorageModified is returned. The secret change is kept pending instead, to be applied once the changes are merged with SaveMerged, or dropped with them when the connections are loaded again.
+func (cm *ConnectionManager) saveWithSecrets(change func(*ConnectionManager) error, update func()) error {
+	err := cm.withLockedStorage(func(storagePath string, current []byte) error {
+		if !bytes.Equal(current, cm.loaded) {
+			return ErrStorageModified
+		}
+
+		if change != nil {
+			if err := change(cm); err != nil {
+				return err
+			}
+		}
+
+		update()
+		if err := cm.save(storagePath); err != nil {
+			return fmt.Errorf("failed to save to disk: %w", err)
+		}
+
+		return nil
+	})
+
+	if errors.Is(err, ErrStorageModified) {
+		if change != nil {
+			cm.pendingSecretChanges = append(cm.pendingSecretChanges, change)
+		}
+		update()
+
+		return fmt.Errorf("failed to save to disk: %w", err)
 	}
 
-	b, err := toml.Marshal(cm)
+	return err
+}
+
+// SaveMerged merges the changes made to the connections since they were loaded into the ones stored on disk, and saves the result. Connections are merged one by one, as are the settings: when one has been changed both locally and on disk, the local changes win.
+//
+// It returns the names of the connections whose changes on disk have been overwritten.
+func (cm *ConnectionManager) SaveMerged() ([]string, error) {
+	var conflicts []string
+
+	err := cm.withLockedStorage(func(storagePath string, current []byte) error {
+		// the secret changes of the local changes are applied first, so that the merged connections are never saved without them
+		for len(cm.pendingSecretChanges) > 0 {
+			if err := cm.pendingSecretChanges[0](cm); err != nil {
+				return err
+			}
+			cm.pendingSecretChanges = cm.pendingSecretChanges[1:]
+		}
+
+		base, _, err := decodeStorage(cm.loaded)
+		if err != nil {
+			return fmt.Errorf("failed to read connections as they were loaded: %v", err)
+		}
+
+		theirs, _, err := decodeStorage(current)
+		if err != nil {
+			return err
+		}
+
+		// compare the local connections as they would be read from disk, so that e.g. nil and empty slices are the same
+		b, err := toml.Marshal(*cm)
+		if err != nil {
+			return err
+		}
+
+		ours, _, err := decodeStorage(b)
+		if err != nil {
+			return err
+		}
+
+		merged, mergeConflicts := mergeManagers(base, ours, theirs)
+		merged.loaded = current
+		if err := merged.save(storagePath); err != nil {
+			return err
+		}
+
+		*cm = merged
+		conflicts = mergeConflicts
+		return nil
+	})
+
+	return conflicts, err
+}
+
+// save writes the connections to the storage file, replacing it at once so that it is never left half written, and regenerates the managed ssh config. The storage file must be locked.
+func (cm *ConnectionManager) save(storagePath string) error {
+	cm.Version = SchemaVersion
+	b, err := toml.Marshal(*cm)
 
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(storagePath, b, StorageFilePerm)
+	if err := writeFileAtomic(storagePath, b, StorageFilePerm); err != nil {
+		return err
+	}
+	cm.loaded = b
 
-	if err != nil {
+	// keep the managed ssh config in sync with the inventory
+	if err := cm.writeManagedSSHConfig(); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// loadFromDisk loads the connections from the TOML file in the user config directory. If the file does not exist, it will be created, and an empty ConnectionManager will be initialized.
+// loadFromDisk loads the connections from the TOML file in the user config directory. If the file does not exist, it will be created, and an empty ConnectionManager will be initialized. Files written with an older schema are migrated and saved again, after a backup of the original has been made.
 func (cm *ConnectionManager) loadFromDisk() error {
+	return cm.withLockedStorage(func(storagePath string, current []byte) error {
+		loaded, version, err := decodeStorage(current)
+		if err != nil {
+			return err
+		}
+
+		*cm = loaded
+		cm.loaded = current
+
+		if version < SchemaVersion {
+			if err := backupStorageFile(storagePath, version, current); err != nil {
+				return err
+			}
+
+			if err := cm.save(storagePath); err != nil {
+				return fmt.Errorf("failed to save to disk after migrating from schema version %d: %w", version, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// withLockedStorage calls fn with the path and the content of the storage file, while holding an exclusive lock on it, so that other instances of ssh-manager cannot write it in between. The storage file is created if it does not exist.
+func (cm *ConnectionManager) withLockedStorage(fn func(storagePath string, current []byte) error) error {
 	storagePath, err := storageFilePath()
 
 	if err != nil {
@@ -58,27 +180,218 @@ func (cm *ConnectionManager) loadFromDisk() error {
 		return err
 	}
 
+	unlock, err := lockStorageFile(storagePath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	b, err := os.ReadFile(storagePath)
 
 	if err != nil {
 		return fmt.Errorf("failed to read file: %w", err)
 	}
 
-	if err := toml.Unmarshal(b, cm); err != nil {
-		return fmt.Errorf("failed to unmarshal connections: %w", err)
+	return fn(storagePath, b)
+}
+
+// lockStorageFile takes an exclusive advisory lock on the storage file, waiting for other instances of ssh-manager to release it. The lock is held on a separate file next to it, as the storage file is replaced every time it is written.
+//
+// It returns a function releasing the lock.
+func lockStorageFile(storagePath string) (func(), error) {
+	f, err := os.OpenFile(storagePath+".lock", os.O_CREATE|os.O_RDWR, StorageFilePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock connections: %w", err)
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path, and renames it to path once it has been flushed to disk, so that path holds either its previous content or data, even if the program stops midway.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to set permissions of temporary file: %w", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to flush temporary file: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", filepath.Base(path), err)
+	}
+
+	return nil
+}
+
+// decodeStorage decodes the content of the storage file, migrating it to the current schema version if it was written with an older one. Empty content holds no connections.
+//
+// It returns the connections, and the schema version the content was written with.
+func decodeStorage(b []byte) (ConnectionManager, int, error) {
+	cm := ConnectionManager{}
+
+	tree, err := toml.LoadBytes(b)
+	if err != nil {
+		return cm, 0, fmt.Errorf("failed to unmarshal connections: %w", err)
+	}
+
+	// an empty file has nothing to migrate
+	version := SchemaVersion
+	if len(tree.Keys()) > 0 {
+		version, err = schemaVersion(tree)
+		if err != nil {
+			return cm, 0, err
+		}
+	}
+
+	if version > SchemaVersion {
+		return cm, 0, fmt.Errorf("%s was written by a newer version of ssh-manager (schema version %d, this version supports up to %d), please upgrade ssh-manager to use it", StorageFileName, version, SchemaVersion)
+	}
+
+	if err := migrate(tree, version); err != nil {
+		return cm, 0, err
+	}
+
+	if err := tree.Unmarshal(&cm); err != nil {
+		return cm, 0, fmt.Errorf("failed to unmarshal connections: %w", err)
+	}
+
+	return cm, version, nil
+}
+
+// SchemaVersion is the version of the schema of the storage file written by this version of ssh-manager. It is the number of migrations, files without a Version key being version 0.
+var SchemaVersion = len(migrations)
+
+// migrations upgrade the storage file from one schema version to the next, migrations[0] upgrading version 0 to version 1. They work on the raw TOML tree, so that fields that are renamed or removed can still be read.
+//
+// New migrations must be appended, and never changed once released.
+var migrations = []func(tree *toml.Tree) error{
+	migrateConnectionIDs,
+}
+
+// schemaVersion returns the schema version of the storage file. Files written before it was versioned are version 0.
+func schemaVersion(tree *toml.Tree) (int, error) {
+	version, ok := tree.GetDefault("Version", int64(0)).(int64)
+	if !ok || version < 0 {
+		return 0, fmt.Errorf("invalid schema version %v", tree.Get("Version"))
+	}
+
+	return int(version), nil
+}
+
+// migrate upgrades the tree from the given schema version to SchemaVersion, one migration at a time.
+func migrate(tree *toml.Tree, version int) error {
+	for ; version < SchemaVersion; version++ {
+		if err := migrations[version](tree); err != nil {
+			return fmt.Errorf("failed to migrate connections from schema version %d to %d: %v", version, version+1, err)
+		}
+		tree.Set("Version", int64(version+1))
+	}
+
+	return nil
+}
+
+// backupStorageFile writes the content of the storage file before it is migrated next to it, e.g. connections.toml.v0-20240102T150405.bak.
+func backupStorageFile(storagePath string, version int, content []byte) error {
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", storagePath, version, time.Now().Format("20060102T150405"))
+	if err := writeFileAtomic(backupPath, content, StorageFilePerm); err != nil {
+		return fmt.Errorf("failed to back up connections before migrating them: %w", err)
+	}
+
+	return nil
+}
+
+// migrateConnectionIDs migrates connections stored before they had an ID. They are given one, jump hosts referenced by destination are replaced by their ID, and stored passwords keep being read from their user@host key until they are stored again.
+func migrateConnectionIDs(tree *toml.Tree) error {
+	tables, _ := tree.Get("Connections").([]*toml.Tree)
+
+	cm := ConnectionManager{}
+	if err := tree.Unmarshal(&cm); err != nil {
+		return err
+	}
+
+	for i := range cm.Connections {
+		conn := &cm.Connections[i]
+		if conn.ID != "" {
+			continue
+		}
+
+		conn.ID = newConnectionID()
+		if conn.IsPassword {
+			conn.LegacySecretKey = fmt.Sprintf("%s@%s", conn.Username, conn.Host)
+		}
+	}
+
+	for i, table := range tables {
+		conn := cm.Connections[i]
+		table.Set("ID", conn.ID)
+		if conn.LegacySecretKey != "" {
+			table.Set("LegacySecretKey", conn.LegacySecretKey)
+		}
+
+		var jumpHosts []interface{}
+		for _, id := range cm.jumpHostIDs(conn.JumpHosts) {
+			jumpHosts = append(jumpHosts, id)
+		}
+		if len(jumpHosts) > 0 {
+			table.Set("JumpHosts", jumpHosts)
+		}
 	}
 
 	return nil
 }
 
-// storageFilePath returns the path to the storage file in the user config directory.
+// StoragePath returns the path of the file the connections are stored in.
+func StoragePath() (string, error) {
+	return storageFilePath()
+}
+
+// storageFilePath returns the path to the storage file: the one given with SetConfigPath or SSH_MANAGER_CONFIG, or the one of the active profile in the user config directory.
 func storageFilePath() (string, error) {
-	configDir, err := os.UserConfigDir()
+	if path := configPathOverride(); path != "" {
+		return path, nil
+	}
+
+	dir, err := configDir()
 	if err != nil {
-		return "", fmt.Errorf("failed to get user config directory: %w", err)
+		return "", err
+	}
+
+	profile, err := ActiveProfile()
+	if err != nil {
+		return "", err
+	}
+
+	if profile != DefaultProfile {
+		dir = filepath.Join(dir, ProfilesDirName, profile)
 	}
-	storagePath := filepath.Join(configDir, StorageDirPrefix, StorageFileName)
-	return storagePath, nil
+
+	return filepath.Join(dir, StorageFileName), nil
 }
 
 // ensureStorageFile ensures that the storage file exists. If the file does not exist, it will be created.
@@ -97,12 +410,12 @@ func ensureStorageFile() error {
 		}
 	}
 
-	// ensure file exists
-	if _, err := os.Stat(storageFilePath); os.IsNotExist(err) {
-		if _, err := os.Create(storageFilePath); err != nil {
-			return fmt.Errorf("failed to create file: %w", err)
-		}
+	// ensure file exists, without truncating it if another instance has just created it
+	f, err := os.OpenFile(storageFilePath, os.O_CREATE|os.O_WRONLY, StorageFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
 	}
+	f.Close()
 
 	return nil
 }
@@ -111,8 +424,7 @@ func ensureStorageFile() error {
 //
 // It returns a ConnectionsFetchedMsg message.
 func (cm ConnectionManager) FetchConnections() tea.Msg {
-	cm = ConnectionManager{}
-	err := cm.loadFromDisk()
+	cm, err := LoadConnections()
 
 	if err != nil {
 		return err
@@ -121,6 +433,14 @@ func (cm ConnectionManager) FetchConnections() tea.Msg {
 	return ConnectionsFetchedMsg{FetchedManager: cm}
 }
 
+// LoadConnections returns a ConnectionManager holding the connections stored on disk.
+func LoadConnections() (ConnectionManager, error) {
+	cm := ConnectionManager{}
+	err := cm.loadFromDisk()
+
+	return cm, err
+}
+
 // ConnectionsFetchedMsg is a bubbletea message that is sent when the connections have been fetched from disk.
 type ConnectionsFetchedMsg struct {
 	FetchedManager ConnectionManager
@@ -0,0 +1,216 @@
+package connection
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/pelletier/go-toml"
+)
+
+func TestMigrateConnectionIDs(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		// want holds the migrated connections without their ID, their jump hosts being given by name
+		want []Connection
+		// keptIDs are the IDs connections already had, indexed by name
+		keptIDs map[string]string
+	}{
+		{
+			name: "connections are given an ID",
+			input: `
+[[Connections]]
+  Username = "root"
+  Host = "web"
+  Port = 22
+`,
+			want: []Connection{{Username: "root", Host: "web", Port: 22}},
+		},
+		{
+			name: "existing IDs are kept",
+			input: `
+[[Connections]]
+  ID = "0123456789abcdef"
+  Alias = "db"
+  Username = "root"
+  Host = "db"
+  Port = 22
+`,
+			want:    []Connection{{Alias: "db", Username: "root", Host: "db", Port: 22}},
+			keptIDs: map[string]string{"db": "0123456789abcdef"},
+		},
+		{
+			name: "passwords are read from their user@host key",
+			input: `
+[[Connections]]
+  Username = "admin"
+  Host = "switch"
+  Port = 2222
+  IsPassword = true
+
+[[Connections]]
+  Username = "admin"
+  Host = "router"
+  Port = 22
+`,
+			want: []Connection{
+				{Username: "admin", Host: "switch", Port: 2222, IsPassword: true, LegacySecretKey: "admin@switch"},
+				{Username: "admin", Host: "router", Port: 22},
+			},
+		},
+		{
+			name: "jump hosts referenced by destination or alias",
+			input: `
+[[Connections]]
+  Username = "app"
+  Host = "inner"
+  Port = 22
+  JumpHosts = ["jump@bastion:22", "gw", "nobody@unknown:22"]
+
+[[Connections]]
+  Username = "jump"
+  Host = "bastion"
+  Port = 22
+
+[[Connections]]
+  Alias = "gw"
+  Username = "ops"
+  Host = "gateway"
+  Port = 22
+`,
+			want: []Connection{
+				{Username: "app", Host: "inner", Port: 22, JumpHosts: []string{"jump@bastion:22", "gw", "nobody@unknown:22"}},
+				{Username: "jump", Host: "bastion", Port: 22},
+				{Alias: "gw", Username: "ops", Host: "gateway", Port: 22},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := toml.Load(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := migrateConnectionIDs(tree); err != nil {
+				t.Fatalf("migrateConnectionIDs returned an error: %v", err)
+			}
+
+			cm := ConnectionManager{}
+			if err := tree.Unmarshal(&cm); err != nil {
+				t.Fatal(err)
+			}
+
+			ids := map[string]bool{}
+			for _, conn := range cm.Connections {
+				if conn.ID == "" || ids[conn.ID] {
+					t.Fatalf("%s was given the ID %q, which is empty or not unique", conn.Name(), conn.ID)
+				}
+				ids[conn.ID] = true
+
+				if id, ok := tt.keptIDs[conn.Name()]; ok && conn.ID != id {
+					t.Errorf("%s was given the ID %q, want it to keep %q", conn.Name(), conn.ID, id)
+				}
+			}
+
+			if got := withJumpHostNames(cm.Connections); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got connections\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeStorage(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantVersion int
+		// wantIDs is whether the connections are expected to have an ID, which they are given when migrating from version 0
+		wantIDs bool
+		wantErr bool
+	}{
+		{
+			name:        "empty file",
+			input:       "",
+			wantVersion: SchemaVersion,
+		},
+		{
+			name: "unversioned file is migrated",
+			input: `
+[[Connections]]
+  Username = "root"
+  Host = "web"
+  Port = 22
+`,
+			wantVersion: 0,
+			wantIDs:     true,
+		},
+		{
+			name: "current version is not migrated",
+			input: fmt.Sprintf(`
+Version = %d
+
+[[Connections]]
+  ID = "0123456789abcdef"
+  Username = "root"
+  Host = "web"
+  Port = 22
+`, SchemaVersion),
+			wantVersion: SchemaVersion,
+			wantIDs:     true,
+		},
+		{
+			name:    "newer version",
+			input:   "Version = 99\n",
+			wantErr: true,
+		},
+		{
+			name:    "negative version",
+			input:   "Version = -1\n",
+			wantErr: true,
+		},
+		{
+			name:    "version of the wrong type",
+			input:   "Version = \"1\"\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid TOML",
+			input:   "[[Connections]\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm, version, err := decodeStorage([]byte(tt.input))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decodeStorage returned no error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("decodeStorage returned an error: %v", err)
+			}
+
+			if version != tt.wantVersion {
+				t.Errorf("got version %d, want %d", version, tt.wantVersion)
+			}
+
+			// an empty file has no Version key until it is saved
+			if tt.input != "" && cm.Version != SchemaVersion {
+				t.Errorf("decoded connections have version %d, want %d", cm.Version, SchemaVersion)
+			}
+
+			for _, conn := range cm.Connections {
+				if hasID := conn.ID != ""; hasID != tt.wantIDs {
+					t.Errorf("%s has ID %q", conn.Name(), conn.ID)
+				}
+			}
+		})
+	}
+}
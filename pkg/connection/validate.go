@@ -0,0 +1,104 @@
+package connection
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// maxHostnameLength is the maximum length of a hostname, see RFC 1035.
+const maxHostnameLength = 253
+
+// ValidateUsername checks that a username only contains letters, digits, dots, underscores and dashes, without starting with a dash, as accepted by most systems. A trailing $ is allowed for machine accounts.
+func ValidateUsername(username string) error {
+	if username == "" {
+		return fmt.Errorf("username is required")
+	}
+
+	if strings.HasPrefix(username, "-") {
+		return fmt.Errorf("username cannot start with a dash")
+	}
+
+	for i, r := range strings.TrimSuffix(username, "$") {
+		if !isAlphanumeric(r) && r != '.' && r != '_' && r != '-' {
+			return fmt.Errorf("invalid character %q in username at position %d", r, i+1)
+		}
+	}
+
+	return nil
+}
+
+// ValidateHost checks that a host is an IPv4 or IPv6 address, or a valid hostname made of dot separated labels of letters, digits, dashes and underscores.
+func ValidateHost(host string) error {
+	if host == "" {
+		return fmt.Errorf("host is required")
+	}
+
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	if strings.Contains(host, ":") {
+		return fmt.Errorf("invalid IPv6 address %s", host)
+	}
+
+	if len(host) > maxHostnameLength {
+		return fmt.Errorf("hostname cannot be longer than %d characters", maxHostnameLength)
+	}
+
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if label == "" {
+			return fmt.Errorf("hostname %s has an empty label", host)
+		}
+
+		if len(label) > 63 {
+			return fmt.Errorf("hostname label %s is longer than 63 characters", label)
+		}
+
+		if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return fmt.Errorf("hostname label %s cannot start or end with a dash", label)
+		}
+
+		for _, r := range label {
+			if !isAlphanumeric(r) && r != '-' && r != '_' {
+				return fmt.Errorf("invalid character %q in hostname", r)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidatePort checks that a port is in the 1-65535 range.
+func ValidatePort(port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("port %d is out of range (1-65535)", port)
+	}
+
+	return nil
+}
+
+// Validate checks the username, host, port and keepalive settings of the connection.
+func (c Connection) Validate() error {
+	if err := ValidateUsername(c.Username); err != nil {
+		return err
+	}
+
+	if err := ValidateHost(c.Host); err != nil {
+		return err
+	}
+
+	if err := ValidatePort(c.Port); err != nil {
+		return err
+	}
+
+	if c.ServerAliveCountMax < 0 {
+		return fmt.Errorf("invalid ServerAliveCountMax %d, it cannot be negative", c.ServerAliveCountMax)
+	}
+
+	return nil
+}
+
+func isAlphanumeric(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
@@ -0,0 +1,437 @@
+package connection
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	VaultFileName = "vault.enc"
+	// VaultPassphraseEnv can hold the vault passphrase, so that it is not prompted for
+	VaultPassphraseEnv = "SSH_MANAGER_VAULT_PASSPHRASE"
+	// DefaultVaultIdleTimeout is how long an unlocked vault stays unlocked without being used
+	DefaultVaultIdleTimeout = 15 * time.Minute
+)
+
+// ErrVaultLocked is returned when the vault needs to be unlocked, and no passphrase prompt is available.
+var ErrVaultLocked = errors.New("vault is locked")
+
+// VaultPassphrasePrompt is called to get the passphrase when the vault is locked. It defaults to reading VaultPassphraseEnv, or prompting on the terminal. Interactive frontends that cannot prompt on the terminal should set it to nil, and unlock the vault with Vault.Unlock when ErrVaultLocked is returned.
+var VaultPassphrasePrompt = PromptVaultPassphrase
+
+// vaultMagic starts every vault file.
+var vaultMagic = []byte("SSHMVLT1")
+
+const (
+	vaultSaltSize = 16
+	// default Argon2id parameters, as recommended by RFC 9106 for memory constrained environments
+	vaultArgonTime    = 3
+	vaultArgonMemory  = 64 * 1024
+	vaultArgonThreads = 4
+	// maxVaultArgonTime and maxVaultArgonMemory bound the costs read from vault headers, the memory in KiB, so that a corrupted header cannot hang ssh-manager or exhaust the memory
+	maxVaultArgonTime   = 100
+	maxVaultArgonMemory = 4 * 1024 * 1024
+)
+
+// vaultKDF holds the key derivation parameters of a vault, stored in its header.
+type vaultKDF struct {
+	salt    []byte
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+// newVaultKDF returns key derivation parameters with a fresh salt.
+func newVaultKDF() (vaultKDF, error) {
+	salt := make([]byte, vaultSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return vaultKDF{}, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	return vaultKDF{salt: salt, time: vaultArgonTime, memory: vaultArgonMemory, threads: vaultArgonThreads}, nil
+}
+
+// header returns the header of a vault file: the magic bytes, followed by the Argon2id parameters and the salt.
+func (kdf vaultKDF) header() []byte {
+	header := append([]byte{}, vaultMagic...)
+	header = binary.BigEndian.AppendUint32(header, kdf.time)
+	header = binary.BigEndian.AppendUint32(header, kdf.memory)
+	header = append(header, kdf.threads)
+	return append(header, kdf.salt...)
+}
+
+func (kdf vaultKDF) key(passphrase string) []byte {
+	return argon2.IDKey([]byte(passphrase), kdf.salt, kdf.time, kdf.memory, kdf.threads, chacha20poly1305.KeySize)
+}
+
+// unlockedVault is the key of an unlocked vault, kept in memory until the vault has not been used for its idle timeout.
+type unlockedVault struct {
+	kdf   vaultKDF
+	key   []byte
+	timer *time.Timer
+}
+
+var (
+	unlockedVaultsMu sync.Mutex
+	// unlockedVaults holds the unlocked vaults, indexed by path
+	unlockedVaults = map[string]*unlockedVault{}
+)
+
+// Vault is the built-in secret store. Secrets are kept in a file next to connections.toml, encrypted with XChaCha20-Poly1305 using a key derived from a master passphrase with Argon2id.
+//
+// The file holds a header with the key derivation parameters, the nonce, and the encrypted JSON object mapping keys to secrets. Once unlocked, the key is cached in memory until the vault has been idle for IdleTimeout.
+type Vault struct {
+	path        string
+	IdleTimeout time.Duration
+	// prompt is called to get the passphrase when the vault is locked, ErrVaultLocked being returned if it is nil
+	prompt func() (string, error)
+}
+
+// OpenVault returns the vault stored next to the storage file. It does not need to exist yet, and has to be unlocked with Unlock before being used, see ConnectionManager.SecretStore for a vault prompting for its passphrase.
+func OpenVault() (*Vault, error) {
+	storagePath, err := storageFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Vault{path: filepath.Join(filepath.Dir(storagePath), VaultFileName), IdleTimeout: DefaultVaultIdleTimeout}, nil
+}
+
+// Exists reports whether the vault file has been created.
+func (v *Vault) Exists() bool {
+	_, err := os.Stat(v.path)
+	return err == nil
+}
+
+// Locked reports whether the vault needs a passphrase before being used.
+func (v *Vault) Locked() bool {
+	unlockedVaultsMu.Lock()
+	defer unlockedVaultsMu.Unlock()
+
+	_, ok := unlockedVaults[v.path]
+	return !ok
+}
+
+// Unlock derives the key of the vault from the passphrase, and keeps it in memory. If the vault does not exist yet, the passphrase becomes its master passphrase.
+func (v *Vault) Unlock(passphrase string) error {
+	b, err := os.ReadFile(v.path)
+	if errors.Is(err, os.ErrNotExist) {
+		kdf, err := newVaultKDF()
+		if err != nil {
+			return err
+		}
+
+		v.cache(kdf, kdf.key(passphrase))
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to read vault: %w", err)
+	}
+
+	kdf, _, err := parseVaultHeader(b)
+	if err != nil {
+		return err
+	}
+
+	key := kdf.key(passphrase)
+	if _, err := decryptVault(b, key); err != nil {
+		return err
+	}
+
+	v.cache(kdf, key)
+	return nil
+}
+
+// Lock forgets the key of the vault.
+func (v *Vault) Lock() {
+	unlockedVaultsMu.Lock()
+	defer unlockedVaultsMu.Unlock()
+
+	if unlocked, ok := unlockedVaults[v.path]; ok {
+		unlocked.timer.Stop()
+		clear(unlocked.key)
+		delete(unlockedVaults, v.path)
+	}
+}
+
+// ChangePassphrase re-encrypts the vault with a key derived from a new passphrase. The new key is only cached once the vault has been saved with it, so that the vault stays unlocked with the current one if saving fails.
+func (v *Vault) ChangePassphrase(passphrase string) error {
+	kdf, err := newVaultKDF()
+	if err != nil {
+		return err
+	}
+	key := kdf.key(passphrase)
+
+	return v.withLock(func() error {
+		secrets, err := v.load()
+		if err != nil {
+			return err
+		}
+
+		if err := v.save(secrets, kdf, key); err != nil {
+			return err
+		}
+
+		v.cache(kdf, key)
+		return nil
+	})
+}
+
+// Rekey re-encrypts the vault with a new salt and the current key derivation parameters, keeping the same passphrase.
+func (v *Vault) Rekey(passphrase string) error {
+	if err := v.Unlock(passphrase); err != nil {
+		return err
+	}
+
+	return v.ChangePassphrase(passphrase)
+}
+
+func (v *Vault) Store(key string, secret string) error {
+	return v.update(func(secrets map[string]string) error {
+		secrets[key] = secret
+		return nil
+	})
+}
+
+func (v *Vault) Remove(key string) error {
+	return v.update(func(secrets map[string]string) error {
+		delete(secrets, key)
+		return nil
+	})
+}
+
+func (v *Vault) Get(key string) (string, error) {
+	secrets, err := v.load()
+	if err != nil {
+		return "", err
+	}
+
+	secret, ok := secrets[key]
+	if !ok {
+		return "", fmt.Errorf("failed to read password: %s is not in the vault", key)
+	}
+
+	return secret, nil
+}
+
+// cache keeps the key of the vault in memory, replacing the previous one.
+func (v *Vault) cache(kdf vaultKDF, key []byte) {
+	v.Lock()
+
+	unlockedVaultsMu.Lock()
+	defer unlockedVaultsMu.Unlock()
+
+	unlockedVaults[v.path] = &unlockedVault{kdf: kdf, key: key, timer: time.AfterFunc(v.IdleTimeout, v.Lock)}
+}
+
+// unlocked returns the key of the vault, unlocking it with its prompt if needed, and resets its idle timeout.
+func (v *Vault) unlocked() (*unlockedVault, error) {
+	if v.Locked() {
+		if v.prompt == nil {
+			return nil, ErrVaultLocked
+		}
+
+		passphrase, err := v.prompt()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get vault passphrase: %v", err)
+		}
+
+		if err := v.Unlock(passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	unlockedVaultsMu.Lock()
+	defer unlockedVaultsMu.Unlock()
+
+	unlocked, ok := unlockedVaults[v.path]
+	if !ok {
+		return nil, ErrVaultLocked
+	}
+
+	unlocked.timer.Reset(v.IdleTimeout)
+	return unlocked, nil
+}
+
+// load decrypts the vault. A vault that does not exist yet is empty.
+func (v *Vault) load() (map[string]string, error) {
+	unlocked, err := v.unlocked()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(v.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault: %w", err)
+	}
+
+	return decryptVault(b, unlocked.key)
+}
+
+// update decrypts the vault, changes its secrets with fn, and saves them with the cached key, while holding an exclusive lock on the vault file so that other instances of ssh-manager cannot change it in between.
+func (v *Vault) update(fn func(secrets map[string]string) error) error {
+	return v.withLock(func() error {
+		secrets, err := v.load()
+		if err != nil {
+			return err
+		}
+
+		if err := fn(secrets); err != nil {
+			return err
+		}
+
+		unlocked, err := v.unlocked()
+		if err != nil {
+			return err
+		}
+
+		return v.save(secrets, unlocked.kdf, unlocked.key)
+	})
+}
+
+// withLock unlocks the vault, and runs fn while holding an exclusive lock on the vault file.
+func (v *Vault) withLock(fn func() error) error {
+	// the passphrase is prompted for before waiting for other instances
+	if _, err := v.unlocked(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(v.path), StorageDirPerm); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	unlock, err := lockStorageFile(v.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return fn()
+}
+
+// save encrypts the secrets with the given key, derived with kdf, and replaces the vault file with them at once, so that it is never left half written. The vault file must be locked.
+func (v *Vault) save(secrets map[string]string, kdf vaultKDF, key []byte) error {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return fmt.Errorf("failed to marshal vault: %w", err)
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	// the header is authenticated as well, so that it cannot be tampered with
+	header := append(kdf.header(), nonce...)
+	b := aead.Seal(header, nonce, plaintext, header)
+
+	if err := writeFileAtomic(v.path, b, StorageFilePerm); err != nil {
+		return fmt.Errorf("failed to write vault: %w", err)
+	}
+
+	return nil
+}
+
+// parseVaultHeader returns the key derivation parameters of a vault file, and the size of its header including the nonce.
+func parseVaultHeader(b []byte) (vaultKDF, int, error) {
+	kdfSize := len(vaultMagic) + 4 + 4 + 1 + vaultSaltSize
+	headerSize := kdfSize + chacha20poly1305.NonceSizeX
+
+	if len(b) < headerSize || !bytes.HasPrefix(b, vaultMagic) {
+		return vaultKDF{}, 0, fmt.Errorf("failed to read vault: not a vault file, or written by a newer version")
+	}
+
+	params := b[len(vaultMagic):kdfSize]
+	kdf := vaultKDF{
+		time:    binary.BigEndian.Uint32(params[0:4]),
+		memory:  binary.BigEndian.Uint32(params[4:8]),
+		threads: params[8],
+		salt:    append([]byte{}, params[9:]...),
+	}
+
+	// argon2 panics on zero parameters
+	if kdf.time < 1 || kdf.time > maxVaultArgonTime || kdf.threads < 1 || kdf.memory < 8*uint32(kdf.threads) || kdf.memory > maxVaultArgonMemory {
+		return vaultKDF{}, 0, fmt.Errorf("failed to read vault: invalid key derivation parameters (time %d, memory %d KiB, threads %d)", kdf.time, kdf.memory, kdf.threads)
+	}
+
+	return kdf, headerSize, nil
+}
+
+func decryptVault(b []byte, key []byte) (map[string]string, error) {
+	_, headerSize, err := parseVaultHeader(b)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return openVault(aead, b, headerSize)
+}
+
+// openVault decrypts the secrets following the header, which ends with the nonce.
+func openVault(aead cipher.AEAD, b []byte, headerSize int) (map[string]string, error) {
+	nonce := b[headerSize-aead.NonceSize() : headerSize]
+
+	plaintext, err := aead.Open(nil, nonce, b[headerSize:], b[:headerSize])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt vault: wrong passphrase or corrupted file")
+	}
+
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal vault: %w", err)
+	}
+
+	return secrets, nil
+}
+
+// PromptVaultPassphrase returns the vault passphrase from VaultPassphraseEnv, or prompts for it on the terminal.
+func PromptVaultPassphrase() (string, error) {
+	if passphrase, ok := os.LookupEnv(VaultPassphraseEnv); ok {
+		return passphrase, nil
+	}
+
+	return PromptPassphrase("Enter vault passphrase:")
+}
+
+// PromptPassphrase prints prompt, and reads a passphrase from the terminal without echoing it.
+func PromptPassphrase(prompt string) (string, error) {
+	in, out, closeTerminal := openTerminal()
+	defer closeTerminal()
+
+	fmt.Fprint(out, prompt)
+	defer fmt.Fprintln(out)
+
+	passphrase, err := term.ReadPassword(in.Fd())
+	if err != nil {
+		return "", err
+	}
+
+	return string(passphrase), nil
+}
@@ -0,0 +1,100 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// errMsg reports a failure to the user in a modal, from which the failed action can be retried.
+type errMsg struct {
+	err error
+	// retry runs the failed action again, it is nil if the action cannot be retried
+	retry func(m *model) tea.Cmd
+	// required is set when the program cannot be used until the action succeeds, such as loading the connections. The modal cannot be dismissed, only retried.
+	required bool
+	// modified is set when the connections could not be saved because another instance of ssh-manager modified them. The changes can be merged into the stored connections, or discarded by reloading them, rather than retried.
+	modified bool
+}
+
+// showError shows err in the error modal, retrying with retry if asked to.
+func (m *model) showError(err error, retry func(m *model) tea.Cmd) {
+	if errors.Is(err, connection.ErrStorageModified) {
+		m.err = &errMsg{err: err, modified: true}
+		return
+	}
+
+	m.err = &errMsg{err: err, retry: retry}
+}
+
+// updateError handles the key presses while the error modal is shown: retrying the failed action, dismissing the modal, or quitting.
+//
+// It returns a slice of commands to be executed.
+func (m *model) updateError(msg tea.KeyMsg) []tea.Cmd {
+	failure := m.err
+
+	switch msg.String() {
+	case "r":
+		if failure.retry != nil {
+			m.err = nil
+			return []tea.Cmd{failure.retry(m)}
+		}
+	case "m":
+		if failure.modified {
+			m.err = nil
+			return []tea.Cmd{m.mergeConnections()}
+		}
+	case "l":
+		if failure.modified {
+			m.err = nil
+			return []tea.Cmd{fetchConnections}
+		}
+	case "esc", "enter":
+		if !failure.required {
+			m.err = nil
+		}
+	case "q", "ctrl+c":
+		return []tea.Cmd{tea.Quit}
+	}
+
+	return nil
+}
+
+// mergeConnections saves the changes made to the connections, merged into the ones modified by another instance of ssh-manager, and reports the connections changed on both sides in the list status bar.
+//
+// It returns a command to be executed.
+func (m *model) mergeConnections() tea.Cmd {
+	conflicts, err := m.manager.SaveMerged()
+	if err != nil {
+		m.showError(err, (*model).mergeConnections)
+		return m.setItems()
+	}
+
+	status := "Merged with the changes of another instance of ssh-manager"
+	if len(conflicts) > 0 {
+		status += fmt.Sprintf(", kept the local changes to %s", strings.Join(conflicts, ", "))
+	}
+
+	return tea.Batch(m.setItems(), m.list.NewStatusMessage(status))
+}
+
+// fetchConnections loads the connections from disk. Failing to do so is reported as a required error, so that nothing overwrites the stored connections before they could be loaded.
+//
+// It returns a ConnectionsFetchedMsg message, or an errMsg.
+func fetchConnections() tea.Msg {
+	msg := connection.ConnectionManager{}.FetchConnections()
+	if err, ok := msg.(error); ok {
+		return errMsg{
+			err: fmt.Errorf("failed to load connections: %w", err),
+			retry: func(*model) tea.Cmd {
+				return fetchConnections
+			},
+			required: true,
+		}
+	}
+
+	return msg
+}
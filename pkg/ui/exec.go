@@ -0,0 +1,320 @@
+package ui
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// execRefreshInterval is how often the output of a running command is refreshed on the results page.
+const execRefreshInterval = 250 * time.Millisecond
+
+// execRun is a command run on several connections from the list, whose results are shown on the results page.
+type execRun struct {
+	command  string
+	hosts    []*execHost
+	selected int
+	finished bool
+	results  chan connection.ExecResult
+}
+
+// execHost is the output and result of the command on one of the connections it runs on.
+type execHost struct {
+	conn   connection.Connection
+	output outputBuffer
+	// result is nil until the command ends
+	result *connection.ExecResult
+}
+
+// outputBuffer collects the output of a command while it runs. It is written by the command and read by the view at the same time.
+type outputBuffer struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	started bool
+}
+
+func (ob *outputBuffer) Write(p []byte) (int, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	return ob.buf.Write(p)
+}
+
+// start marks the command as started, once it is no longer waiting for other connections to finish.
+func (ob *outputBuffer) start() {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.started = true
+}
+
+// snapshot returns the output so far, and whether the command has started.
+func (ob *outputBuffer) snapshot() (string, bool) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	return ob.buf.String(), ob.started
+}
+
+// execResultMsg is sent every time the command of a run ends on one of its connections, and once more with ok unset when it has ended on all of them.
+type execResultMsg struct {
+	run    *execRun
+	result connection.ExecResult
+	ok     bool
+}
+
+// execTickMsg is sent periodically while a command runs, to show its output as it comes.
+type execTickMsg struct {
+	run *execRun
+}
+
+// execTargets returns the connections a command typed on the home page runs on, along with a description of them: the connections of the selected group, the ones shown in the list when it is filtered by text or tag, or the selected connection otherwise.
+func (m model) execTargets() ([]connection.Connection, string) {
+	if group, ok := m.list.SelectedItem().(connection.GroupItem); ok {
+		description := "group " + group.Path
+		if m.tagFilter != "" {
+			description += " #" + m.tagFilter
+		}
+
+		return m.manager.Select(connection.Selector{Group: group.Path, Tag: m.tagFilter}), description
+	}
+
+	if m.list.FilterState() != list.Unfiltered {
+		var targets []connection.Connection
+		for _, listItem := range m.list.VisibleItems() {
+			if item, ok := listItem.(connection.Item); ok {
+				targets = append(targets, item.Conn)
+			}
+		}
+
+		return targets, "filtered connections"
+	}
+
+	if m.tagFilter != "" {
+		return m.manager.Select(connection.Selector{Tag: m.tagFilter}), "#" + m.tagFilter
+	}
+
+	if item, ok := m.list.SelectedItem().(connection.Item); ok {
+		return []connection.Connection{item.Conn}, item.Conn.Name()
+	}
+
+	return nil, ""
+}
+
+// showRunCommand switches to the page asking for the command to run on the connections returned by execTargets.
+//
+// It returns a command to be executed.
+func (m *model) showRunCommand() tea.Cmd {
+	targets, description := m.execTargets()
+	if len(targets) == 0 {
+		return m.list.NewStatusMessage("No connection to run a command on")
+	}
+
+	m.execTargetConns = targets
+	m.execTargetName = description
+	m.currentPage = runCommand
+	m.commandInput.Reset()
+
+	return m.commandInput.Focus()
+}
+
+// updateRunCommand handles the key presses when asked for the command to run. The vault is unlocked first if any of the connections needs it.
+//
+// It returns a slice of commands to be executed.
+func (m *model) updateRunCommand(msg tea.Msg) []tea.Cmd {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "esc":
+			m.commandInput.Blur()
+			m.currentPage = home
+			return nil
+		case "enter":
+			command := strings.TrimSpace(m.commandInput.Value())
+			if command == "" {
+				return nil
+			}
+
+			if m.execNeedsVault() {
+				return []tea.Cmd{m.showUnlockVault()}
+			}
+
+			m.commandInput.Blur()
+			return []tea.Cmd{m.runCommand(command)}
+		}
+	}
+
+	var cmd tea.Cmd
+	m.commandInput, cmd = m.commandInput.Update(msg)
+
+	return []tea.Cmd{cmd}
+}
+
+// execNeedsVault reports whether any of the connections the command runs on, or their jump hosts, needs the vault while it is locked.
+func (m model) execNeedsVault() bool {
+	for _, conn := range m.execTargetConns {
+		jumps, err := m.manager.JumpChain(conn)
+		if err != nil {
+			continue
+		}
+
+		for _, hop := range append(jumps, conn) {
+			if hop.IsPassword && m.vaultLocked(hop) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// runCommand runs the command on the connections returned by execTargets in the background, and switches to the page showing its results as they come.
+//
+// It returns a command to be executed.
+func (m *model) runCommand(command string) tea.Cmd {
+	run := &execRun{
+		command: command,
+		results: make(chan connection.ExecResult, len(m.execTargetConns)),
+	}
+
+	hosts := map[string]*execHost{}
+	for _, conn := range m.execTargetConns {
+		host := &execHost{conn: conn}
+		run.hosts = append(run.hosts, host)
+		hosts[conn.ID] = host
+	}
+
+	opts := connection.ExecAllOptions{
+		Command: command,
+		Output: func(conn connection.Connection) (io.Writer, io.Writer) {
+			host := hosts[conn.ID]
+			host.output.start()
+
+			// stdout and stderr are shown together, as they would be on a terminal
+			return &host.output, &host.output
+		},
+		Done: func(result connection.ExecResult) {
+			run.results <- result
+		},
+	}
+
+	manager, targets := m.manager, m.execTargetConns
+	go func() {
+		manager.ExecAll(targets, opts)
+		close(run.results)
+	}()
+
+	m.execRun = run
+	m.currentPage = execResults
+
+	return tea.Batch(waitForExecResult(run), tickExecRun(run))
+}
+
+// waitForExecResult returns a command waiting for the command of the run to end on the next connection.
+func waitForExecResult(run *execRun) tea.Cmd {
+	return func() tea.Msg {
+		result, ok := <-run.results
+		return execResultMsg{run: run, result: result, ok: ok}
+	}
+}
+
+// tickExecRun returns a command sending an execTickMsg once the refresh interval has elapsed.
+func tickExecRun(run *execRun) tea.Cmd {
+	return tea.Tick(execRefreshInterval, func(time.Time) tea.Msg {
+		return execTickMsg{run: run}
+	})
+}
+
+// handleExecResult records the result of the command on one of the connections of its run. Results of previous runs still going on in the background are kept with them, but not shown anymore.
+//
+// It returns a command to be executed.
+func (m *model) handleExecResult(msg execResultMsg) tea.Cmd {
+	if !msg.ok {
+		msg.run.finished = true
+		return nil
+	}
+
+	for _, host := range msg.run.hosts {
+		if host.conn.ID == msg.result.Conn.ID {
+			result := msg.result
+			host.result = &result
+		}
+	}
+
+	return waitForExecResult(msg.run)
+}
+
+// updateExecResults handles the key presses on the results page, which selects the connection whose output is shown. Going back to the list shows a summary of the results once the command has ended everywhere.
+//
+// It returns a slice of commands to be executed.
+func (m *model) updateExecResults(msg tea.Msg) []tea.Cmd {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return nil
+	}
+
+	run := m.execRun
+	switch keyMsg.String() {
+	case "up", "k":
+		run.selected = max(run.selected-1, 0)
+	case "down", "j":
+		run.selected = min(run.selected+1, len(run.hosts)-1)
+	case "esc", "q":
+		m.currentPage = home
+		if run.finished {
+			return []tea.Cmd{m.showResult(run.summary())}
+		}
+	}
+
+	return nil
+}
+
+// summary describes how many connections the command succeeded and failed on, e.g. "uptime: 3 succeeded, 1 failed".
+func (run *execRun) summary() string {
+	succeeded, failed := 0, 0
+	for _, host := range run.hosts {
+		if host.result != nil && host.result.Err == nil && host.result.ExitStatus == 0 {
+			succeeded++
+		} else {
+			failed++
+		}
+	}
+
+	return fmt.Sprintf("%s: %d succeeded, %d failed", run.command, succeeded, failed)
+}
+
+// running returns the number of connections the command has not ended on yet.
+func (run *execRun) running() int {
+	running := 0
+	for _, host := range run.hosts {
+		if host.result == nil {
+			running++
+		}
+	}
+
+	return running
+}
+
+// status describes where the command is at on the connection, e.g. "exit 0 · 1.2s".
+func (host *execHost) status() string {
+	if host.result == nil {
+		if _, started := host.output.snapshot(); started {
+			return "running"
+		}
+
+		return "waiting"
+	}
+
+	duration := host.result.Duration.Round(time.Millisecond)
+	if host.result.Err != nil {
+		return fmt.Sprintf("failed · %s", duration)
+	}
+
+	return fmt.Sprintf("exit %d · %s", host.result.ExitStatus, duration)
+}
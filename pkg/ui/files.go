@@ -0,0 +1,603 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// transferRefreshInterval is how often the progress bar of a transfer is refreshed.
+const transferRefreshInterval = 100 * time.Millisecond
+
+// indexes of the panes of the file browser
+const (
+	localPane = iota
+	remotePane
+)
+
+// fileAction is what the text typed at the bottom of the file browser is for.
+type fileAction int
+
+const (
+	noFileAction fileAction = iota
+	renameFileAction
+	mkdirFileAction
+)
+
+// fileBrowser browses the local files and the ones of a host side by side, to copy files between them.
+type fileBrowser struct {
+	conn   connection.Connection
+	remote *connection.SFTPFileSystem
+	panes  [2]*filePane
+	active int
+	action fileAction
+	input  textinput.Model
+	// confirm is the question waiting for y or n, and confirmed what is done on y
+	confirm   string
+	confirmed func(m *model) tea.Cmd
+	transfer  *fileTransfer
+	progress  progress.Model
+	status    string
+	err       string
+}
+
+// filePane lists a directory of a file system.
+type filePane struct {
+	fs      connection.FileSystem
+	name    string
+	dir     string
+	entries []os.FileInfo
+	cursor  int
+}
+
+// fileTransfer is a file being copied from one pane to the other in the background.
+type fileTransfer struct {
+	name   string
+	upload bool
+	size   int64
+	copied atomic.Int64
+	start  time.Time
+	// target is the pane the file is copied to
+	target int
+	done   chan error
+}
+
+// sftpOpenedMsg is sent once the SFTP session of the file browser has been opened, or failed to.
+type sftpOpenedMsg struct {
+	conn connection.Connection
+	fs   *connection.SFTPFileSystem
+	err  error
+}
+
+// transferDoneMsg is sent once a transfer is over.
+type transferDoneMsg struct {
+	transfer *fileTransfer
+	err      error
+}
+
+// transferTickMsg is sent periodically while a transfer runs, to refresh its progress bar.
+type transferTickMsg struct {
+	transfer *fileTransfer
+}
+
+// paneListedMsg is sent once a directory of a pane has been listed, or failed to be. Listing happens in the background, so that slow connections do not freeze the interface.
+type paneListedMsg struct {
+	pane    *filePane
+	dir     string
+	entries []os.FileInfo
+	// selected is the name of the entry to select, the one at cursor being selected if it is not there
+	cursor   int
+	selected string
+	err      error
+}
+
+// fileChangedMsg is sent once a file of a pane has been renamed, deleted or created, or failed to be.
+type fileChangedMsg struct {
+	pane   *filePane
+	status string
+	// selected is the name of the entry to select once the directory has been listed again
+	selected string
+	err      error
+}
+
+// copyCheckedMsg is sent once it is known whether copying a file would replace another one.
+type copyCheckedMsg struct {
+	source     *filePane
+	sourceName string
+	target     *filePane
+	targetName string
+	size       int64
+	// err is nil if the target exists
+	err error
+}
+
+// sftpCommand opens an SFTP session while the program is suspended, so that what is needed to authenticate can be prompted for on the terminal. It implements tea.ExecCommand.
+type sftpCommand struct {
+	manager connection.ConnectionManager
+	conn    connection.Connection
+	fs      *connection.SFTPFileSystem
+}
+
+// Run opens the SFTP session. The terminal is released meanwhile, so that the vault passphrase or unknown host keys can be prompted for on it.
+func (sc *sftpCommand) Run() error {
+	var err error
+	sc.fs, err = sc.manager.OpenSFTP(sc.conn)
+
+	return err
+}
+
+// the session is opened on the terminal of the process, which is the one the program uses
+func (sc *sftpCommand) SetStdin(io.Reader)  {}
+func (sc *sftpCommand) SetStdout(io.Writer) {}
+func (sc *sftpCommand) SetStderr(io.Writer) {}
+
+// openFileBrowser opens an SFTP session with conn, to browse its files once opened.
+//
+// It returns a command sending an sftpOpenedMsg.
+func (m model) openFileBrowser(conn connection.Connection) tea.Cmd {
+	command := &sftpCommand{manager: m.manager, conn: conn}
+
+	return tea.Exec(command, func(err error) tea.Msg {
+		return sftpOpenedMsg{conn: conn, fs: command.fs, err: err}
+	})
+}
+
+// showFileBrowser switches to the file browser once its SFTP session is opened, and starts listing the current local directory and the directory the session starts in.
+//
+// It returns a command to be executed.
+func (m *model) showFileBrowser(msg sftpOpenedMsg) tea.Cmd {
+	if msg.err != nil {
+		conn := msg.conn
+		m.showError(fmt.Errorf("%s: %w", conn.Name(), msg.err), func(m *model) tea.Cmd {
+			return m.openFileBrowser(conn)
+		})
+		return nil
+	}
+
+	b := &fileBrowser{
+		conn:     msg.conn,
+		remote:   msg.fs,
+		active:   remotePane,
+		input:    textinput.New(),
+		progress: progress.New(progress.WithDefaultGradient()),
+	}
+	b.input.PromptStyle = focusedStyle
+	b.input.TextStyle = focusedStyle
+
+	b.panes[localPane] = &filePane{fs: connection.LocalFileSystem{}, name: "Local"}
+	b.panes[remotePane] = &filePane{fs: msg.fs, name: msg.conn.Name()}
+
+	m.files = b
+	m.currentPage = browseFiles
+
+	return tea.Batch(b.panes[localPane].open(), b.panes[remotePane].open())
+}
+
+// closeFileBrowser ends the SFTP session, interrupting the transfer if one is running, and goes back to the list.
+func (m *model) closeFileBrowser() {
+	m.files.remote.Close()
+	m.files = nil
+	m.currentPage = home
+}
+
+// updateFileBrowser handles the key presses in the file browser.
+//
+// It returns a slice of commands to be executed.
+func (m *model) updateFileBrowser(msg tea.Msg) []tea.Cmd {
+	b := m.files
+
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		if b.action == noFileAction {
+			return nil
+		}
+
+		var cmd tea.Cmd
+		b.input, cmd = b.input.Update(msg)
+		return []tea.Cmd{cmd}
+	}
+
+	if b.confirm != "" {
+		confirmed := b.confirmed
+		switch keyMsg.String() {
+		case "y":
+			b.confirm, b.confirmed = "", nil
+			return []tea.Cmd{confirmed(m)}
+		case "n", "esc":
+			b.confirm, b.confirmed = "", nil
+		}
+		return nil
+	}
+
+	if b.action != noFileAction {
+		return b.updateInput(keyMsg)
+	}
+
+	pane := b.panes[b.active]
+	b.err = ""
+
+	// nothing can be done in a pane until its first directory has been listed
+	if pane.dir == "" && !slices.Contains([]string{"tab", "esc", "q"}, keyMsg.String()) {
+		return nil
+	}
+
+	switch keyMsg.String() {
+	case "tab":
+		b.active = (b.active + 1) % len(b.panes)
+	case "up", "k":
+		pane.cursor = max(pane.cursor-1, 0)
+	case "down", "j":
+		pane.cursor = min(pane.cursor+1, max(len(pane.entries)-1, 0))
+	case "enter", "right", "l":
+		if entry := pane.selected(); entry != nil && entry.IsDir() {
+			return []tea.Cmd{pane.list(pane.fs.Join(pane.dir, entry.Name()), 0, "")}
+		}
+	case "backspace", "left", "h":
+		return []tea.Cmd{pane.parent()}
+	case "c":
+		return []tea.Cmd{b.copySelected()}
+	case "r":
+		if entry := pane.selected(); entry != nil {
+			b.startInput(renameFileAction, entry.Name())
+		}
+	case "n":
+		b.startInput(mkdirFileAction, "")
+	case "x", "delete":
+		entry := pane.selected()
+		if entry == nil {
+			break
+		}
+
+		name := pane.fs.Join(pane.dir, entry.Name())
+		b.confirm = fmt.Sprintf("Delete %s? (y/n)", name)
+		if entry.IsDir() {
+			b.confirm = fmt.Sprintf("Delete %s and everything in it? (y/n)", name)
+		}
+		b.confirmed = func(*model) tea.Cmd {
+			return pane.change(func(fs connection.FileSystem) error {
+				return fs.RemoveAll(name)
+			}, "Deleted "+name, "failed to delete "+name, "")
+		}
+	case "esc", "q":
+		if b.transfer == nil {
+			m.closeFileBrowser()
+			break
+		}
+
+		b.confirm = fmt.Sprintf("%s is still being copied, interrupt it and close? (y/n)", b.transfer.name)
+		b.confirmed = func(m *model) tea.Cmd {
+			m.closeFileBrowser()
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// startInput asks for a name at the bottom of the file browser, for the given action.
+func (b *fileBrowser) startInput(action fileAction, value string) {
+	b.action = action
+	b.input.Placeholder = "Name"
+	b.input.SetValue(value)
+	b.input.CursorEnd()
+	b.input.Focus()
+}
+
+// updateInput handles the key presses while a name is being typed, renaming the selected file or creating a directory with it once entered.
+//
+// It returns a slice of commands to be executed.
+func (b *fileBrowser) updateInput(msg tea.KeyMsg) []tea.Cmd {
+	pane := b.panes[b.active]
+	b.err = ""
+
+	switch msg.String() {
+	case "esc":
+		b.action = noFileAction
+		b.input.Blur()
+		return nil
+	case "enter":
+		name := strings.TrimSpace(b.input.Value())
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			b.err = fmt.Sprintf("invalid name %q", name)
+			return nil
+		}
+
+		target := pane.fs.Join(pane.dir, name)
+		var cmd tea.Cmd
+		switch b.action {
+		case renameFileAction:
+			entry := pane.selected()
+			if entry == nil {
+				break
+			}
+
+			source := pane.fs.Join(pane.dir, entry.Name())
+			cmd = pane.change(func(fs connection.FileSystem) error {
+				return fs.Rename(source, target)
+			}, fmt.Sprintf("Renamed %s to %s", entry.Name(), name), "failed to rename "+entry.Name(), name)
+		case mkdirFileAction:
+			cmd = pane.change(func(fs connection.FileSystem) error {
+				return fs.Mkdir(target)
+			}, "Created "+target, "failed to create "+target, name)
+		}
+
+		b.action = noFileAction
+		b.input.Blur()
+		return []tea.Cmd{cmd}
+	}
+
+	var cmd tea.Cmd
+	b.input, cmd = b.input.Update(msg)
+
+	return []tea.Cmd{cmd}
+}
+
+// copySelected starts copying the selected file of the active pane to the directory of the other one, uploading or downloading it. Whether a file with the same name would be replaced is checked in the background first, see handleCopyChecked.
+//
+// It returns a command to be executed.
+func (b *fileBrowser) copySelected() tea.Cmd {
+	if b.transfer != nil {
+		b.err = b.transfer.name + " is still being copied"
+		return nil
+	}
+
+	source, target := b.panes[b.active], b.panes[(b.active+1)%len(b.panes)]
+	entry := source.selected()
+	if entry == nil {
+		return nil
+	}
+
+	if entry.IsDir() {
+		b.err = "only files can be copied, not directories"
+		return nil
+	}
+
+	msg := copyCheckedMsg{
+		source:     source,
+		sourceName: source.fs.Join(source.dir, entry.Name()),
+		target:     target,
+		targetName: target.fs.Join(target.dir, entry.Name()),
+		size:       entry.Size(),
+	}
+
+	return func() tea.Msg {
+		_, msg.err = msg.target.fs.Stat(msg.targetName)
+		return msg
+	}
+}
+
+// handleCopyChecked starts the copy checked by copySelected, once confirmed if it replaces a file with the same name.
+//
+// It returns a command to be executed.
+func (m *model) handleCopyChecked(msg copyCheckedMsg) tea.Cmd {
+	b := m.files
+	if b == nil || !slices.Contains(b.panes[:], msg.source) {
+		return nil
+	}
+
+	// another copy may have been started while this one was being checked
+	if b.transfer != nil {
+		b.err = b.transfer.name + " is still being copied"
+		return nil
+	}
+
+	transfer := func(*model) tea.Cmd {
+		return b.startTransfer(msg.source, msg.sourceName, msg.target, msg.targetName, msg.size)
+	}
+
+	if msg.err == nil {
+		b.confirm = fmt.Sprintf("%s already exists, replace it? (y/n)", msg.targetName)
+		b.confirmed = transfer
+		return nil
+	}
+
+	if !errors.Is(msg.err, os.ErrNotExist) {
+		b.err = fmt.Sprintf("failed to read %s: %v", msg.targetName, msg.err)
+		return nil
+	}
+
+	return transfer(m)
+}
+
+// startTransfer copies a file from the source pane to the target one in the background.
+//
+// It returns a command to be executed.
+func (b *fileBrowser) startTransfer(source *filePane, sourceName string, target *filePane, targetName string, size int64) tea.Cmd {
+	t := &fileTransfer{
+		name:   sourceName,
+		upload: target == b.panes[remotePane],
+		size:   size,
+		start:  time.Now(),
+		target: slices.Index(b.panes[:], target),
+		done:   make(chan error, 1),
+	}
+
+	go func() {
+		_, err := connection.CopyFile(target.fs, targetName, source.fs, sourceName, func(copied int64) {
+			t.copied.Store(copied)
+		})
+		t.done <- err
+	}()
+
+	b.transfer = t
+	b.status, b.err = "", ""
+
+	return tea.Batch(waitForTransfer(t), tickTransfer(t))
+}
+
+// waitForTransfer returns a command waiting for the transfer to be over.
+func waitForTransfer(t *fileTransfer) tea.Cmd {
+	return func() tea.Msg {
+		return transferDoneMsg{transfer: t, err: <-t.done}
+	}
+}
+
+// tickTransfer returns a command sending a transferTickMsg once the refresh interval has elapsed.
+func tickTransfer(t *fileTransfer) tea.Cmd {
+	return tea.Tick(transferRefreshInterval, func(time.Time) tea.Msg {
+		return transferTickMsg{transfer: t}
+	})
+}
+
+// handleTransferDone reports how the transfer went, and lists the directory the file was copied to again. Transfers of file browsers that have been closed since are ignored.
+//
+// It returns a command to be executed.
+func (m *model) handleTransferDone(msg transferDoneMsg) tea.Cmd {
+	b := m.files
+	if b == nil || b.transfer != msg.transfer {
+		return nil
+	}
+
+	b.transfer = nil
+	if msg.err != nil {
+		b.err = msg.err.Error()
+	} else {
+		verb := "Downloaded"
+		if msg.transfer.upload {
+			verb = "Uploaded"
+		}
+
+		b.status = fmt.Sprintf("%s %s (%s) in %s", verb, msg.transfer.name, connection.FormatBytes(msg.transfer.copied.Load()), time.Since(msg.transfer.start).Round(time.Millisecond))
+	}
+
+	return b.panes[msg.transfer.target].reload("")
+}
+
+// handlePaneListed shows the directory listed in its pane. The previous directory is kept if it could not be listed, and the file browser is closed if it is the first one, as it cannot be used without it. Panes of file browsers that have been closed since are ignored.
+func (m *model) handlePaneListed(msg paneListedMsg) {
+	b := m.files
+	if b == nil || !slices.Contains(b.panes[:], msg.pane) {
+		return
+	}
+
+	pane := msg.pane
+	if msg.err != nil {
+		if pane.dir == "" {
+			m.closeFileBrowser()
+			m.showError(fmt.Errorf("%s: %w", pane.name, msg.err), nil)
+			return
+		}
+
+		b.err = msg.err.Error()
+		return
+	}
+
+	pane.dir, pane.entries = msg.dir, msg.entries
+	pane.cursor = min(msg.cursor, max(len(pane.entries)-1, 0))
+	pane.selectName(msg.selected)
+}
+
+// handleFileChanged reports how a change made to the files of a pane went, and lists its directory again.
+//
+// It returns a command to be executed.
+func (m *model) handleFileChanged(msg fileChangedMsg) tea.Cmd {
+	b := m.files
+	if b == nil || !slices.Contains(b.panes[:], msg.pane) {
+		return nil
+	}
+
+	if msg.err != nil {
+		b.err = msg.err.Error()
+	} else {
+		b.status = msg.status
+	}
+
+	return msg.pane.reload(msg.selected)
+}
+
+// open returns a command listing the directory the file system of the pane starts in.
+func (p *filePane) open() tea.Cmd {
+	fs := p.fs
+
+	return func() tea.Msg {
+		dir, err := fs.Getwd()
+		if err != nil {
+			return paneListedMsg{pane: p, err: fmt.Errorf("failed to get the current directory: %v", err)}
+		}
+
+		return p.list(dir, 0, "")()
+	}
+}
+
+// list returns a command listing the directory in the background, directories first, and selecting the entry named selected once listed, or the one at cursor if there is none.
+func (p *filePane) list(dir string, cursor int, selected string) tea.Cmd {
+	fs := p.fs
+
+	return func() tea.Msg {
+		entries, err := fs.ReadDir(dir)
+		if err != nil {
+			return paneListedMsg{pane: p, err: fmt.Errorf("failed to list %s: %v", dir, err)}
+		}
+
+		slices.SortFunc(entries, func(a, b os.FileInfo) int {
+			if a.IsDir() != b.IsDir() {
+				if a.IsDir() {
+					return -1
+				}
+				return 1
+			}
+
+			return strings.Compare(a.Name(), b.Name())
+		})
+
+		return paneListedMsg{pane: p, dir: dir, entries: entries, cursor: cursor, selected: selected}
+	}
+}
+
+// reload returns a command listing the directory again, selecting the entry named selected, or keeping the selected entry if it is empty and the entry still exists.
+func (p *filePane) reload(selected string) tea.Cmd {
+	if entry := p.selected(); entry != nil && selected == "" {
+		selected = entry.Name()
+	}
+
+	return p.list(p.dir, p.cursor, selected)
+}
+
+// parent returns a command going to the parent directory, selecting the directory it comes from.
+func (p *filePane) parent() tea.Cmd {
+	parent := p.fs.Dir(p.dir)
+	if parent == p.dir {
+		return nil
+	}
+
+	return p.list(parent, 0, strings.TrimLeft(strings.TrimPrefix(p.dir, parent), `/\`))
+}
+
+// change returns a command changing the files of the pane with fn in the background, reporting status once done, or the error prefixed with failure. The entry named selected is selected once the directory has been listed again.
+func (p *filePane) change(fn func(fs connection.FileSystem) error, status, failure, selected string) tea.Cmd {
+	fs := p.fs
+
+	return func() tea.Msg {
+		if err := fn(fs); err != nil {
+			return fileChangedMsg{pane: p, selected: selected, err: fmt.Errorf("%s: %v", failure, err)}
+		}
+
+		return fileChangedMsg{pane: p, status: status, selected: selected}
+	}
+}
+
+// selectName selects the entry with the given name, if there is one.
+func (p *filePane) selectName(name string) {
+	if i := slices.IndexFunc(p.entries, func(entry os.FileInfo) bool { return entry.Name() == name }); i >= 0 {
+		p.cursor = i
+	}
+}
+
+// selected returns the selected entry, or nil if the directory is empty.
+func (p *filePane) selected() os.FileInfo {
+	if p.cursor >= len(p.entries) {
+		return nil
+	}
+
+	return p.entries[p.cursor]
+}
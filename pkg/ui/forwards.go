@@ -0,0 +1,140 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// forwardsRefreshInterval is how often the traffic of the running forwards is refreshed.
+const forwardsRefreshInterval = time.Second
+
+// forwardsOpenedMsg is sent once the forwards of a connection have been opened, or failed to.
+type forwardsOpenedMsg struct {
+	conn    connection.Connection
+	session *connection.ForwardSession
+	err     error
+}
+
+// forwardsEndedMsg is sent once the connection of running forwards ends, err being set if it was lost.
+type forwardsEndedMsg struct {
+	session *connection.ForwardSession
+	err     error
+}
+
+// forwardsTickMsg is sent periodically while forwards are running, to refresh their traffic.
+type forwardsTickMsg struct {
+	session *connection.ForwardSession
+}
+
+// forwardsCommand opens the forwards of a connection while the program is suspended, so that what is needed to authenticate can be prompted for on the terminal. It implements tea.ExecCommand.
+type forwardsCommand struct {
+	manager connection.ConnectionManager
+	conn    connection.Connection
+	session *connection.ForwardSession
+}
+
+// Run opens the forwards. The terminal is released meanwhile, so that the vault passphrase or unknown host keys can be prompted for on it.
+func (fc *forwardsCommand) Run() error {
+	var err error
+	fc.session, err = fc.manager.OpenForwards(fc.conn)
+
+	return err
+}
+
+// the connection is opened on the terminal of the process, which is the one the program uses
+func (fc *forwardsCommand) SetStdin(io.Reader)  {}
+func (fc *forwardsCommand) SetStdout(io.Writer) {}
+func (fc *forwardsCommand) SetStderr(io.Writer) {}
+
+// openForwards opens the forwards of conn without starting a shell, to watch their traffic once opened.
+//
+// It returns a command sending a forwardsOpenedMsg.
+func (m model) openForwards(conn connection.Connection) tea.Cmd {
+	command := &forwardsCommand{manager: m.manager, conn: conn}
+
+	return tea.Exec(command, func(err error) tea.Msg {
+		return forwardsOpenedMsg{conn: conn, session: command.session, err: err}
+	})
+}
+
+// showForwards switches to the page showing the traffic of the forwards once they are opened.
+//
+// It returns a command to be executed.
+func (m *model) showForwards(msg forwardsOpenedMsg) tea.Cmd {
+	if msg.err != nil {
+		conn := msg.conn
+		m.showError(fmt.Errorf("%s: %w", conn.Name(), msg.err), func(m *model) tea.Cmd {
+			return m.openForwards(conn)
+		})
+		return nil
+	}
+
+	m.forwards = msg.session
+	m.currentPage = watchForwards
+
+	return tea.Batch(waitForForwards(msg.session), tickForwards(msg.session))
+}
+
+// waitForForwards returns a command waiting for the connection of the forwards to end.
+func waitForForwards(session *connection.ForwardSession) tea.Cmd {
+	return func() tea.Msg {
+		return forwardsEndedMsg{session: session, err: session.Wait()}
+	}
+}
+
+// tickForwards returns a command sending a forwardsTickMsg once the refresh interval has elapsed.
+func tickForwards(session *connection.ForwardSession) tea.Cmd {
+	return tea.Tick(forwardsRefreshInterval, func(time.Time) tea.Msg {
+		return forwardsTickMsg{session: session}
+	})
+}
+
+// handleForwardsEnded goes back to the list if the connection of the shown forwards was lost. Forwards closed from their page have already been left.
+func (m *model) handleForwardsEnded(msg forwardsEndedMsg) {
+	if msg.session != m.forwards || msg.err == nil {
+		return
+	}
+
+	conn := msg.session.Conn
+	m.forwards = nil
+	m.currentPage = home
+	m.showError(fmt.Errorf("%s: %w after %s", conn.Name(), msg.err, forwardsSummary(msg.session)), func(m *model) tea.Cmd {
+		return m.openForwards(conn)
+	})
+}
+
+// updateForwards handles the key presses on the forwards page, which closes the forwards when leaving it and shows their traffic in the status bar.
+//
+// It returns a slice of commands to be executed.
+func (m *model) updateForwards(msg tea.Msg) []tea.Cmd {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return nil
+	}
+
+	switch keyMsg.String() {
+	case "esc", "q":
+		session := m.forwards
+		session.Close()
+		m.forwards = nil
+		m.currentPage = home
+
+		return []tea.Cmd{m.showResult(session.Conn.Name() + ": forwards closed after " + forwardsSummary(session))}
+	}
+
+	return nil
+}
+
+// forwardsSummary describes how long the forwards ran, along with their traffic.
+func forwardsSummary(session *connection.ForwardSession) string {
+	summary := time.Since(session.Start).Round(time.Second).String()
+	for _, stat := range session.Stats {
+		summary += " · " + stat.String()
+	}
+
+	return summary
+}
@@ -1,9 +1,11 @@
 package ui
 
 import (
-	"log"
-	"strconv"
+	"errors"
+	"fmt"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
@@ -14,7 +16,15 @@ import (
 
 type keyMap struct {
 	insertItem     key.Binding
+	editItem       key.Binding
 	deleteItem     key.Binding
+	importConfig   key.Binding
+	filterTag      key.Binding
+	checkHosts     key.Binding
+	switchProfile  key.Binding
+	runCommand     key.Binding
+	browseFiles    key.Binding
+	openForwards   key.Binding
 	connect        key.Binding
 	toggleHelpMenu key.Binding
 	quit           key.Binding
@@ -22,21 +32,74 @@ type keyMap struct {
 
 type page int
 
+// resultLifetime is how long the results shown by showResult stay in the status bar if no key is pressed, which is long enough to come back to the terminal.
+const resultLifetime = 24 * time.Hour
+
+// indexes of the text inputs in the add connection form
+const (
+	sshInputIndex = iota
+	aliasInputIndex
+	groupInputIndex
+	tagsInputIndex
+	passwordInputIndex
+	identityFilesInputIndex
+	jumpHostsInputIndex
+	forwardsInputIndex
+)
+
+// indexes of the toggles in the add connection form, which come after the text inputs
+const (
+	identitiesOnlyToggleIndex = iota
+	keySourceToggleIndex
+	reconnectToggleIndex
+	forwardAgentToggleIndex
+	// only shown when editing a connection
+	removePasswordToggleIndex
+)
+
 const (
 	home page = iota
 	addConnection
+	editConnection
+	confirmHostKey
+	unlockVault
+	runCommand
+	execResults
+	browseFiles
+	watchForwards
 )
 
 type model struct {
-	manager            connection.ConnectionManager
-	list               list.Model
-	keys               *keyMap
-	selectedConnection *connection.Connection
-	inputs             []textinput.Model
-	focusedInputIndex  int
-	currentPage        page
-	width              int
-	height             int
+	manager           connection.ConnectionManager
+	list              list.Model
+	keys              *keyMap
+	pendingHostKey    *connection.HostKeyCheckedMsg
+	passphraseInput   textinput.Model
+	newPassphrase     string
+	vaultErr          string
+	previousPage      page
+	inputs            []textinput.Model
+	toggles           []toggle
+	focusedInputIndex int
+	inputErrors       []string
+	formErr           string
+	err               *errMsg
+	checker           *connection.ReachabilityChecker
+	reachability      map[string]connection.Reachability
+	pendingChecks     int
+	tagFilter         string
+	editedConnection  connection.Connection
+	editedIndex       int
+	commandInput      textinput.Model
+	execTargetConns   []connection.Connection
+	execTargetName    string
+	execRun           *execRun
+	files             *fileBrowser
+	forwards          *connection.ForwardSession
+	resultShown       bool
+	currentPage       page
+	width             int
+	height            int
 }
 
 func newKeyMap() *keyMap {
@@ -45,13 +108,45 @@ func newKeyMap() *keyMap {
 			key.WithKeys("a"),
 			key.WithHelp("a", "add connection"),
 		),
+		editItem: key.NewBinding(
+			key.WithKeys("e"),
+			key.WithHelp("e", "edit connection"),
+		),
 		deleteItem: key.NewBinding(
 			key.WithKeys("d"),
 			key.WithHelp("d", "delete connection"),
 		),
+		importConfig: key.NewBinding(
+			key.WithKeys("i"),
+			key.WithHelp("i", "import ~/.ssh/config"),
+		),
+		filterTag: key.NewBinding(
+			key.WithKeys("t"),
+			key.WithHelp("t", "filter by tag"),
+		),
+		checkHosts: key.NewBinding(
+			key.WithKeys("r"),
+			key.WithHelp("r", "check reachability"),
+		),
+		switchProfile: key.NewBinding(
+			key.WithKeys("p"),
+			key.WithHelp("p", "switch profile"),
+		),
+		runCommand: key.NewBinding(
+			key.WithKeys("x"),
+			key.WithHelp("x", "run command"),
+		),
+		browseFiles: key.NewBinding(
+			key.WithKeys("f"),
+			key.WithHelp("f", "browse files"),
+		),
+		openForwards: key.NewBinding(
+			key.WithKeys("w"),
+			key.WithHelp("w", "open forwards"),
+		),
 		connect: key.NewBinding(
 			key.WithKeys("enter"),
-			key.WithHelp("enter", "connect"),
+			key.WithHelp("enter", "connect/toggle group"),
 		),
 		toggleHelpMenu: key.NewBinding(
 			key.WithKeys("?"),
@@ -65,10 +160,11 @@ func newKeyMap() *keyMap {
 
 func initialModel() model {
 	var (
-		cm     = connection.ConnectionManager{}
-		list   = list.New(cm.Items(), list.NewDefaultDelegate(), 0, 0)
-		keys   = newKeyMap()
-		inputs = make([]textinput.Model, 2)
+		cm      = connection.ConnectionManager{}
+		list    = list.New(cm.Items(""), list.NewDefaultDelegate(), 0, 0)
+		keys    = newKeyMap()
+		inputs  = make([]textinput.Model, 8)
+		toggles = make([]toggle, 5)
 	)
 
 	// initialize text inputs
@@ -78,6 +174,21 @@ func initialModel() model {
 	sshInput.TextStyle = focusedStyle
 	sshInput.Focus()
 
+	aliasInput := textinput.New()
+	aliasInput.Placeholder = "Alias (e.g. prod-db-1, optional)"
+	aliasInput.PromptStyle = blurredStyle
+	aliasInput.TextStyle = blurredStyle
+
+	groupInput := textinput.New()
+	groupInput.Placeholder = "Group (e.g. prod/db, optional)"
+	groupInput.PromptStyle = blurredStyle
+	groupInput.TextStyle = blurredStyle
+
+	tagsInput := textinput.New()
+	tagsInput.Placeholder = "Tags (comma separated, optional)"
+	tagsInput.PromptStyle = blurredStyle
+	tagsInput.TextStyle = blurredStyle
+
 	passwordInput := textinput.New()
 	passwordInput.Placeholder = "Password (optional)"
 	passwordInput.PromptStyle = blurredStyle
@@ -85,11 +196,57 @@ func initialModel() model {
 	passwordInput.EchoMode = textinput.EchoPassword
 	passwordInput.EchoCharacter = '•'
 
-	inputs[0] = sshInput
-	inputs[1] = passwordInput
+	identityFilesInput := textinput.New()
+	identityFilesInput.Placeholder = "Identity files (comma separated, optional)"
+	identityFilesInput.PromptStyle = blurredStyle
+	identityFilesInput.TextStyle = blurredStyle
+
+	jumpHostsInput := textinput.New()
+	jumpHostsInput.Placeholder = "Jump hosts (comma separated aliases or user@host:port, optional)"
+	jumpHostsInput.PromptStyle = blurredStyle
+	jumpHostsInput.TextStyle = blurredStyle
+
+	forwardsInput := textinput.New()
+	forwardsInput.Placeholder = "Forwards (comma separated, e.g. L 5432:db:5432, D 1080, optional)"
+	forwardsInput.PromptStyle = blurredStyle
+	forwardsInput.TextStyle = blurredStyle
+
+	inputs[sshInputIndex] = sshInput
+	inputs[aliasInputIndex] = aliasInput
+	inputs[groupInputIndex] = groupInput
+	inputs[tagsInputIndex] = tagsInput
+	inputs[passwordInputIndex] = passwordInput
+	inputs[identityFilesInputIndex] = identityFilesInput
+	inputs[jumpHostsInputIndex] = jumpHostsInput
+	inputs[forwardsInputIndex] = forwardsInput
+
+	passphraseInput := textinput.New()
+	passphraseInput.Placeholder = "Vault passphrase"
+	passphraseInput.PromptStyle = focusedStyle
+	passphraseInput.TextStyle = focusedStyle
+	passphraseInput.EchoMode = textinput.EchoPassword
+	passphraseInput.EchoCharacter = '•'
+
+	commandInput := textinput.New()
+	commandInput.Placeholder = "Command (e.g. uptime)"
+	commandInput.PromptStyle = focusedStyle
+	commandInput.TextStyle = focusedStyle
+
+	// initialize toggles
+	toggles[identitiesOnlyToggleIndex] = newToggle("Only use these identity files")
+	toggles[keySourceToggleIndex] = newChoice("Keys",
+		string(connection.KeySourceBoth),
+		string(connection.KeySourceAgent),
+		string(connection.KeySourceFiles),
+	)
+	toggles[reconnectToggleIndex] = newToggle("Reconnect when the connection is lost")
+	toggles[forwardAgentToggleIndex] = newToggle("Forward the ssh-agent (only to trusted hosts)")
+	toggles[removePasswordToggleIndex] = newToggle("Remove stored password")
 
 	// initialize list
 	list.Title = "Available connections"
+	// f browses files, it does not go to the next page
+	list.KeyMap.NextPage.SetKeys("right", "l", "pgdown", "d")
 	list.AdditionalShortHelpKeys = func() []key.Binding {
 		return []key.Binding{
 			keys.insertItem,
@@ -100,6 +257,14 @@ func initialModel() model {
 	list.AdditionalFullHelpKeys = func() []key.Binding {
 		return []key.Binding{
 			keys.insertItem,
+			keys.editItem,
+			keys.filterTag,
+			keys.checkHosts,
+			keys.switchProfile,
+			keys.runCommand,
+			keys.browseFiles,
+			keys.openForwards,
+			keys.importConfig,
 			keys.connect,
 			keys.toggleHelpMenu,
 		}
@@ -112,48 +277,134 @@ func initialModel() model {
 		keys:              keys,
 		currentPage:       home,
 		inputs:            inputs,
+		toggles:           toggles,
+		passphraseInput:   passphraseInput,
+		commandInput:      commandInput,
+		reachability:      map[string]connection.Reachability{},
 		focusedInputIndex: 0,
 	}
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	cmds := []tea.Cmd{}
+	page := m.currentPage
+
+	// the error modal is shown on top of the current page, and takes the key presses until it is dismissed
+	if msg, ok := msg.(tea.KeyMsg); ok && m.err != nil {
+		return m, tea.Batch(m.updateError(msg)...)
+	}
 
 	switch m.currentPage {
 	case home:
 		switch msg := msg.(type) {
 		case tea.KeyMsg:
+			if m.resultShown {
+				m.hideResult()
+			}
+
 			if m.list.FilterState() == list.Filtering {
 				break
 			}
 			switch {
 			case key.Matches(msg, m.keys.connect):
-				if len(m.list.Items()) == 0 {
+				if group, ok := m.list.SelectedItem().(connection.GroupItem); ok {
+					cmds = append(cmds, m.toggleGroup(group.Path))
 					break
 				}
-				selectedItem := m.list.SelectedItem().(connection.Item)
-				m.selectedConnection = &selectedItem.Conn
-				cmds = append(cmds, tea.Quit)
+
+				selectedItem, ok := m.list.SelectedItem().(connection.Item)
+				if !ok {
+					break
+				}
+
+				if selectedItem.Conn.IsPassword && m.vaultLocked(selectedItem.Conn) {
+					cmds = append(cmds, m.showUnlockVault())
+					break
+				}
+
+				// hosts behind jump hosts can only be reached once authenticated, so their keys are confirmed on the terminal during the session instead
+				if len(selectedItem.Conn.JumpHosts) > 0 {
+					cmds = append(cmds, m.startSession(selectedItem.Conn))
+					break
+				}
+
+				cmds = append(cmds, selectedItem.Conn.VerifyHostKey)
 			case key.Matches(msg, m.keys.deleteItem):
-				if len(m.list.Items()) == 0 {
+				selectedItem, ok := m.list.SelectedItem().(connection.Item)
+				if !ok {
+					break
+				}
+				cmds = append(cmds, m.deleteConnection(selectedItem.Conn.ID))
+			case key.Matches(msg, m.keys.filterTag):
+				cmds = append(cmds, m.nextTagFilter())
+			case key.Matches(msg, m.keys.checkHosts):
+				cmds = append(cmds, m.checkReachability())
+			case key.Matches(msg, m.keys.switchProfile):
+				cmds = append(cmds, m.switchProfile())
+			case key.Matches(msg, m.keys.runCommand):
+				cmds = append(cmds, m.showRunCommand())
+			case key.Matches(msg, m.keys.browseFiles):
+				selectedItem, ok := m.list.SelectedItem().(connection.Item)
+				if !ok {
 					break
 				}
-				err := m.manager.DeleteConnection(m.list.Index())
 
-				if err != nil {
-					log.Fatal(err)
+				if selectedItem.Conn.IsPassword && m.vaultLocked(selectedItem.Conn) {
+					cmds = append(cmds, m.showUnlockVault())
+					break
+				}
+
+				cmds = append(cmds, m.openFileBrowser(selectedItem.Conn))
+			case key.Matches(msg, m.keys.openForwards):
+				selectedItem, ok := m.list.SelectedItem().(connection.Item)
+				if !ok {
+					break
 				}
 
-				cmds = append(cmds, m.list.SetItems(m.manager.Items()))
+				if len(selectedItem.Conn.Forwards) == 0 {
+					cmds = append(cmds, m.list.NewStatusMessage(selectedItem.Conn.Name()+" has no forwards"))
+					break
+				}
 
+				if selectedItem.Conn.IsPassword && m.vaultLocked(selectedItem.Conn) {
+					cmds = append(cmds, m.showUnlockVault())
+					break
+				}
+
+				cmds = append(cmds, m.openForwards(selectedItem.Conn))
+
+			case key.Matches(msg, m.keys.importConfig):
+				cmds = append(cmds, m.importSSHConfig())
 			case key.Matches(msg, m.keys.insertItem):
+				cmds = append(cmds, m.resetForm()...)
 				m.currentPage = addConnection
+			case key.Matches(msg, m.keys.editItem):
+				selectedItem, ok := m.list.SelectedItem().(connection.Item)
+				if !ok {
+					break
+				}
+				// the list index cannot be used, as it is relative to the filtered items
+				m.editedIndex = m.manager.Index(selectedItem.Conn.ID)
+				cmds = append(cmds, m.fillForm(selectedItem.Conn)...)
+				m.currentPage = editConnection
 			case key.Matches(msg, m.keys.quit):
 				cmds = append(cmds, tea.Quit)
 			}
 		}
-	case addConnection:
+	case addConnection, editConnection:
 		cmds = append(cmds, m.updateAddConnection(msg)...)
+	case confirmHostKey:
+		cmds = append(cmds, m.updateConfirmHostKey(msg)...)
+	case unlockVault:
+		cmds = append(cmds, m.updateUnlockVault(msg)...)
+	case runCommand:
+		cmds = append(cmds, m.updateRunCommand(msg)...)
+	case execResults:
+		cmds = append(cmds, m.updateExecResults(msg)...)
+	case browseFiles:
+		cmds = append(cmds, m.updateFileBrowser(msg)...)
+	case watchForwards:
+		cmds = append(cmds, m.updateForwards(msg)...)
 	}
 
 	switch msg := msg.(type) {
@@ -161,14 +412,105 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.handleResizing(msg)
 	case connection.ConnectionsFetchedMsg:
 		m.manager = msg.FetchedManager
-		cmds = append(cmds, m.list.SetItems(m.manager.Items()))
+		cmds = append(cmds, m.setItems())
+
+		// connections are fetched again when switching profiles or reloading them, checks are only scheduled the first time
+		scheduled := m.checker != nil
+		checker, err := connection.NewReachabilityChecker(m.manager.Checks)
+		if err != nil {
+			m.showError(err, nil)
+		} else {
+			m.checker = checker
+			cmds = append(cmds, m.checkReachability())
+			if !scheduled {
+				cmds = append(cmds, m.scheduleReachabilityCheck())
+			}
+		}
+
+		// unlock the vault right away, so that it is only asked for once
+		if m.manager.UsesVault() && m.vaultLocked(connection.Connection{SecretBackend: connection.SecretBackendVault}) {
+			cmds = append(cmds, m.showUnlockVault())
+		}
+	case connection.HostKeyCheckedMsg:
+		if msg.Err != nil {
+			conn := msg.Conn
+			m.showError(msg.Err, func(*model) tea.Cmd {
+				return conn.VerifyHostKey
+			})
+			break
+		}
+
+		if msg.Status == connection.HostKeyUnknown {
+			m.pendingHostKey = &msg
+			m.currentPage = confirmHostKey
+			break
+		}
+
+		// changed and revoked keys are refused with a clear error when starting the session
+		cmds = append(cmds, m.startSession(msg.Conn))
+	case sessionEndedMsg:
+		if msg.err != nil {
+			conn := msg.result.Conn
+			m.showError(fmt.Errorf("%s: %w", conn.Name(), msg.err), func(m *model) tea.Cmd {
+				return m.startSession(conn)
+			})
+			break
+		}
+
+		cmds = append(cmds, m.showResult(msg.status()))
+	case errMsg:
+		m.err = &msg
+	case execResultMsg:
+		cmds = append(cmds, m.handleExecResult(msg))
+	case sftpOpenedMsg:
+		cmds = append(cmds, m.showFileBrowser(msg))
+	case transferDoneMsg:
+		cmds = append(cmds, m.handleTransferDone(msg))
+	case paneListedMsg:
+		m.handlePaneListed(msg)
+	case fileChangedMsg:
+		cmds = append(cmds, m.handleFileChanged(msg))
+	case copyCheckedMsg:
+		cmds = append(cmds, m.handleCopyChecked(msg))
+	case transferTickMsg:
+		if m.files != nil && m.files.transfer == msg.transfer {
+			cmds = append(cmds, tickTransfer(msg.transfer))
+		}
+	case forwardsOpenedMsg:
+		cmds = append(cmds, m.showForwards(msg))
+	case forwardsEndedMsg:
+		m.handleForwardsEnded(msg)
+	case forwardsTickMsg:
+		if msg.session == m.forwards {
+			cmds = append(cmds, tickForwards(msg.session))
+		}
+	case execTickMsg:
+		if !msg.run.finished && msg.run == m.execRun {
+			cmds = append(cmds, tickExecRun(msg.run))
+		}
+	case reachabilityCheckedMsg:
+		m.pendingChecks--
+		m.reachability[msg.id] = msg.reachability
+		cmds = append(cmds, m.setItems())
+	case reachabilityTickMsg:
+		// connections are only checked while the list is visible
+		if m.currentPage == home && m.err == nil {
+			cmds = append(cmds, m.checkReachability())
+		}
+		cmds = append(cmds, m.scheduleReachabilityCheck())
 	}
 
 	// update the list and inputs with the current message
 	var listCmd, inputsCmd tea.Cmd
 
-	m.list, listCmd = m.list.Update(msg)
-	inputsCmd = m.updateInputs(msg)
+	// the list only takes the key presses made on it, so that e.g. a "q" in the vault passphrase or esc on a form do not quit through its key bindings
+	if _, ok := msg.(tea.KeyMsg); !ok || page == home {
+		m.list, listCmd = m.list.Update(msg)
+	}
+	// the form inputs only take the key presses made on the form, so that e.g. the vault passphrase never ends up in them
+	if page == addConnection || page == editConnection {
+		inputsCmd = m.updateInputs(msg)
+	}
 
 	cmds = append(cmds, listCmd, inputsCmd)
 
@@ -176,7 +518,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m model) Init() tea.Cmd {
-	return tea.Batch(m.manager.FetchConnections, textinput.Blink)
+	return tea.Batch(fetchConnections, textinput.Blink)
 }
 
 // updateInputs updates the text inputs when typing into them.
@@ -200,70 +542,506 @@ func (m *model) updateAddConnection(msg tea.Msg) []tea.Cmd {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		switch msg.String() {
-		case "tab", "shift+tab", "up", "down", "enter":
+		case "tab", "shift+tab", "up", "down":
 			cmds = append(cmds, m.handleInputNavigation(msg.String())...)
+		case "enter":
 			// TODO: add I/O with config file
-			if msg.String() == "enter" {
-				host, username, port, password, err := m.parseConnectionInput()
-				if err != nil {
-					log.Fatal(err)
-				}
-				m.manager.AddConnection(host, username, port, password)
-				m.currentPage = home
+			conn, password, ok := m.parseConnectionInput()
+			if !ok {
+				// the form stays open on the first invalid input
+				invalidIndex := slices.IndexFunc(m.inputErrors, func(err string) bool {
+					return err != ""
+				})
+				return m.focusField(invalidIndex)
+			}
 
-				cmd := m.list.SetItems(m.manager.Items())
+			// the form stays filled, so that the connection can be saved once the vault is unlocked
+			if (password != nil || m.editedConnection.IsPassword) && m.vaultLocked(conn) {
+				return []tea.Cmd{m.showUnlockVault()}
+			}
 
-				cmds = append(cmds, cmd)
+			var err error
+			if m.currentPage == editConnection {
+				// fields that are not part of the form are kept as they were
+				conn.SecretBackend = m.editedConnection.SecretBackend
+				conn.ServerAliveInterval = m.editedConnection.ServerAliveInterval
+				conn.ServerAliveCountMax = m.editedConnection.ServerAliveCountMax
+
+				err = m.manager.EditConnection(m.editedIndex, conn, password, m.toggles[removePasswordToggleIndex].checked())
+			} else {
+				err = m.manager.AddConnection(conn, password)
+			}
+
+			if errors.Is(err, connection.ErrStorageModified) {
+				// the change is kept in the manager, until it is merged or discarded
+				m.currentPage = home
+				m.showError(err, nil)
+				return []tea.Cmd{m.setItems()}
 			}
 
+			if err != nil {
+				m.formErr = err.Error()
+				return nil
+			}
+			m.currentPage = home
+
+			cmds = append(cmds, m.setItems())
+		case " ":
+			toggleIndex := m.focusedInputIndex - len(m.inputs)
+			if toggleIndex >= 0 && toggleIndex < m.toggleCount() {
+				m.toggles[toggleIndex].next()
+			}
+		case "esc":
+			// only the form is closed, the list is shown as it was left, with its filter and selection
+			if m.focusedInputIndex < len(m.inputs) {
+				m.inputs[m.focusedInputIndex].Blur()
+			}
+			m.currentPage = home
 		}
-		return nil
 	}
 	return cmds
 }
 
-// parseConnectionInput parses the input from the text inputs (ssh string and password).
+// showResult shows the result of an action in the list status bar until the next key press, instead of the lifetime of status messages, as there is no telling how long it takes to read it.
+//
+// It returns a command to be executed.
+func (m *model) showResult(status string) tea.Cmd {
+	lifetime := m.list.StatusMessageLifetime
+	m.list.StatusMessageLifetime = resultLifetime
+	cmd := m.list.NewStatusMessage(status)
+	m.list.StatusMessageLifetime = lifetime
+
+	m.resultShown = true
+	return cmd
+}
+
+// hideResult hides the result shown by showResult.
+func (m *model) hideResult() {
+	// the status message is replaced right away, the command only hides it once more after its lifetime
+	m.list.NewStatusMessage("")
+	m.resultShown = false
+}
+
+// importSSHConfig imports the hosts from ~/.ssh/config, and reports the result in the list status bar.
 //
-// Returns all the necessary data to create a SSH connection, or an error if the input is invalid in any way.
-func (m *model) parseConnectionInput() (host, username string, port int, password *string, err error) {
-	parts := strings.Split(m.inputs[0].Value(), "@")
+// It returns a command to be executed.
+func (m *model) importSSHConfig() tea.Cmd {
+	path, err := connection.DefaultSSHConfigPath()
+	if err != nil {
+		m.showError(err, nil)
+		return nil
+	}
+
+	result, err := m.manager.ImportSSHConfig(path)
+	if err != nil {
+		m.showError(err, (*model).importSSHConfig)
+		return m.setItems()
+	}
 
-	username = parts[0]
-	parts = strings.Split(parts[1], ":")
+	status := fmt.Sprintf("Imported %d connections, skipped %d duplicates", len(result.Added), len(result.Duplicates))
+	if len(result.Invalid) > 0 {
+		status += fmt.Sprintf(" and %d invalid hosts", len(result.Invalid))
+	}
+	if len(result.Warnings) > 0 {
+		status += fmt.Sprintf(" (%d warnings, run ssh-manager import for details)", len(result.Warnings))
+	}
 
-	host = parts[0]
+	return tea.Batch(m.setItems(), m.showResult(status))
+}
 
-	if len(parts) == 1 {
-		port = 0
+// toggleGroup collapses or expands the group with the given path.
+//
+// It returns a command to be executed.
+func (m *model) toggleGroup(path string) tea.Cmd {
+	if err := m.manager.ToggleGroup(path); err != nil {
+		m.showError(err, func(m *model) tea.Cmd {
+			return m.toggleGroup(path)
+		})
+	}
+
+	return m.setItems()
+}
+
+// switchProfile makes the profile after the active one active, and loads its connections.
+//
+// It returns a command to be executed.
+func (m *model) switchProfile() tea.Cmd {
+	if !connection.UsesProfiles() {
+		return m.list.NewStatusMessage("Profiles cannot be switched when using -config or SSH_MANAGER_CONFIG")
+	}
+
+	profiles, err := connection.Profiles()
+	if err != nil {
+		m.showError(err, (*model).switchProfile)
+		return nil
+	}
+
+	if len(profiles) < 2 {
+		return m.list.NewStatusMessage("No other profile, create one with ssh-manager profile use <name>")
+	}
+
+	active, err := connection.ActiveProfile()
+	if err != nil {
+		m.showError(err, nil)
+		return nil
+	}
+
+	next := profiles[(slices.Index(profiles, active)+1)%len(profiles)]
+	if err := connection.UseProfile(next); err != nil {
+		m.showError(err, (*model).switchProfile)
+		return nil
+	}
+
+	// tags and check results belong to the connections of the previous profile
+	m.tagFilter = ""
+	m.reachability = map[string]connection.Reachability{}
+
+	return fetchConnections
+}
+
+// storageName returns what the list title shows of where the connections are stored: the active profile, or the storage file when profiles are not used.
+func storageName() string {
+	if !connection.UsesProfiles() {
+		path, _ := connection.StoragePath()
+		return path
+	}
+
+	profile, _ := connection.ActiveProfile()
+	return profile
+}
+
+// deleteConnection deletes the connection with the given ID, along with its stored password.
+//
+// It returns a command to be executed.
+func (m *model) deleteConnection(id string) tea.Cmd {
+	index := m.manager.Index(id)
+	if index < 0 {
+		return m.setItems()
+	}
+
+	if err := m.manager.DeleteConnection(index); err != nil {
+		m.showError(err, func(m *model) tea.Cmd {
+			return m.deleteConnection(id)
+		})
+	}
+
+	return m.setItems()
+}
+
+// trustHostKey adds the host key waiting for confirmation to known_hosts, and connects to the host.
+//
+// It returns a command to be executed.
+func (m *model) trustHostKey(pending connection.HostKeyCheckedMsg) tea.Cmd {
+	if err := connection.AddKnownHost(pending.Conn.Address(), pending.Key); err != nil {
+		m.showError(err, func(m *model) tea.Cmd {
+			return m.trustHostKey(pending)
+		})
+		return nil
+	}
+
+	return m.startSession(pending.Conn)
+}
+
+// setItems refreshes the items of the list from the manager, keeping only the connections with the tag being filtered on, along with the result of their last reachability check.
+//
+// It returns a command to be executed.
+func (m *model) setItems() tea.Cmd {
+	m.list.Title = "Available connections"
+	if storage := storageName(); storage != "" {
+		m.list.Title += " · " + storage
+	}
+	if m.tagFilter != "" {
+		m.list.Title += " #" + m.tagFilter
+	}
+
+	items := m.manager.Items(m.tagFilter)
+	for i, listItem := range items {
+		if item, ok := listItem.(connection.Item); ok {
+			item.Reachability = m.reachability[item.Conn.ID]
+			items[i] = item
+		}
+	}
+
+	return m.list.SetItems(items)
+}
+
+// nextTagFilter filters the list on the next tag used by the connections, in alphabetical order. Once past the last tag, the filter is cleared.
+//
+// It returns a command to be executed.
+func (m *model) nextTagFilter() tea.Cmd {
+	tags := m.manager.Tags()
+
+	// -1 when no tag is being filtered on, so that the first one is selected
+	i := slices.Index(tags, m.tagFilter)
+	if i+1 < len(tags) {
+		m.tagFilter = tags[i+1]
 	} else {
-		port, err = strconv.Atoi(parts[1])
+		m.tagFilter = ""
+	}
+
+	return m.setItems()
+}
+
+// updateConfirmHostKey handles the key presses when asked to trust the host key of an unknown server. Trusting it adds it to known_hosts and connects.
+//
+// It returns a slice of commands to be executed.
+func (m *model) updateConfirmHostKey(msg tea.Msg) []tea.Cmd {
+	var cmds []tea.Cmd
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "y":
+			cmds = append(cmds, m.trustHostKey(*m.pendingHostKey))
+			m.pendingHostKey = nil
+			m.currentPage = home
+		case "n", "q", "esc":
+			m.pendingHostKey = nil
+			m.currentPage = home
+		}
+	}
+	return cmds
+}
+
+// vaultLocked reports whether the password of conn is kept in the vault, and the vault needs to be unlocked first.
+func (m model) vaultLocked(conn connection.Connection) bool {
+	if m.manager.SecretBackend(conn) != connection.SecretBackendVault {
+		return false
+	}
+
+	vault, err := connection.OpenVault()
+	if err != nil {
+		return false
+	}
+
+	return vault.Locked()
+}
+
+// showUnlockVault switches to the page asking for the vault passphrase, which goes back to the current page once unlocked.
+//
+// It returns a command to be executed.
+func (m *model) showUnlockVault() tea.Cmd {
+	m.previousPage = m.currentPage
+	m.currentPage = unlockVault
+	m.vaultErr = ""
+	m.newPassphrase = ""
+	m.passphraseInput.Reset()
+
+	return m.passphraseInput.Focus()
+}
+
+// updateUnlockVault handles the key presses when asked for the vault passphrase. If the vault does not exist yet, the passphrase is asked twice to create it.
+//
+// It returns a slice of commands to be executed.
+func (m *model) updateUnlockVault(msg tea.Msg) []tea.Cmd {
+	var cmds []tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "esc":
+			m.passphraseInput.Blur()
+			m.currentPage = m.previousPage
+			return nil
+		case "enter":
+			vault, err := connection.OpenVault()
+			if err != nil {
+				m.vaultErr = err.Error()
+				return nil
+			}
+
+			passphrase := m.passphraseInput.Value()
+			m.passphraseInput.Reset()
+
+			if !vault.Exists() && m.newPassphrase == "" {
+				if passphrase == "" {
+					m.vaultErr = "passphrase cannot be empty"
+					return nil
+				}
+
+				m.newPassphrase = passphrase
+				m.vaultErr = ""
+				return nil
+			}
+
+			if !vault.Exists() && m.newPassphrase != passphrase {
+				m.newPassphrase = ""
+				m.vaultErr = "passphrases do not match"
+				return nil
+			}
+
+			if err := vault.Unlock(passphrase); err != nil {
+				m.vaultErr = err.Error()
+				return nil
+			}
+
+			m.passphraseInput.Blur()
+			m.currentPage = m.previousPage
+			return []tea.Cmd{m.list.NewStatusMessage("Vault unlocked")}
+		}
 	}
 
+	var cmd tea.Cmd
+	m.passphraseInput, cmd = m.passphraseInput.Update(msg)
+	cmds = append(cmds, cmd)
+
+	return cmds
+}
+
+// parseConnectionInput builds a connection from the form inputs, validating each of them. Errors are stored in inputErrors, to be shown under the offending input.
+//
+// It returns the connection, the password to store if one was typed, and whether the inputs are valid.
+func (m *model) parseConnectionInput() (conn connection.Connection, password *string, ok bool) {
+	m.inputErrors = make([]string, len(m.inputs))
+
+	destination, err := connection.ParseDestination(m.inputs[sshInputIndex].Value())
 	if err != nil {
-		// TODO: show error
-		return "", "", 0, nil, err
+		m.inputErrors[sshInputIndex] = err.Error()
+	}
+
+	conn.Username, conn.Host, conn.Port = destination.Username, destination.Host, destination.Port
+	conn.Alias = strings.TrimSpace(m.inputs[aliasInputIndex].Value())
+	conn.Group = m.inputs[groupInputIndex].Value()
+	conn.Tags = strings.Split(m.inputs[tagsInputIndex].Value(), ",")
+
+	// empty when adding a connection, so that the alias is checked against every stored one
+	conn.ID = m.editedConnection.ID
+	if err := m.manager.ValidateAlias(conn); err != nil {
+		m.inputErrors[aliasInputIndex] = err.Error()
 	}
 
-	if strings.TrimSpace(m.inputs[1].Value()) != "" {
-		passwordInput := m.inputs[1].Value()
+	if strings.TrimSpace(m.inputs[passwordInputIndex].Value()) != "" {
+		passwordInput := m.inputs[passwordInputIndex].Value()
 		password = &passwordInput
 	}
 
-	return host, username, port, password, nil
+	for _, identityFile := range strings.Split(m.inputs[identityFilesInputIndex].Value(), ",") {
+		if identityFile = strings.TrimSpace(identityFile); identityFile != "" {
+			conn.IdentityFiles = append(conn.IdentityFiles, identityFile)
+		}
+	}
+
+	for _, jumpHost := range strings.Split(m.inputs[jumpHostsInputIndex].Value(), ",") {
+		if jumpHost = strings.TrimSpace(jumpHost); jumpHost == "" {
+			continue
+		}
+
+		jump, found := m.manager.Find(jumpHost)
+		switch {
+		case !found:
+			m.inputErrors[jumpHostsInputIndex] = fmt.Sprintf("jump host %s is not a stored connection", jumpHost)
+		case conn.ID != "" && jump.ID == conn.ID:
+			m.inputErrors[jumpHostsInputIndex] = "a connection cannot be its own jump host"
+		}
+
+		conn.JumpHosts = append(conn.JumpHosts, jumpHost)
+	}
+
+	for _, spec := range strings.Split(m.inputs[forwardsInputIndex].Value(), ",") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+
+		forward, err := connection.ParseForward(spec)
+		if err != nil {
+			m.inputErrors[forwardsInputIndex] = err.Error()
+			continue
+		}
+
+		conn.Forwards = append(conn.Forwards, forward)
+	}
+
+	conn.IdentitiesOnly = m.toggles[identitiesOnlyToggleIndex].checked()
+	conn.KeySource = connection.KeySource(m.toggles[keySourceToggleIndex].value())
+	conn.Reconnect = m.toggles[reconnectToggleIndex].checked()
+	conn.ForwardAgent = m.toggles[forwardAgentToggleIndex].checked()
+
+	return conn, password, !slices.ContainsFunc(m.inputErrors, func(err string) bool {
+		return err != ""
+	})
 }
 
-// handleInputNavigation handles the navigation between the text inputs and the button.
+// resetForm empties the connection form, and focuses its first input.
+//
+// It returns a slice of commands to be executed.
+func (m *model) resetForm() []tea.Cmd {
+	m.editedConnection = connection.Connection{}
+	m.inputErrors = nil
+	m.formErr = ""
+
+	for i := range m.inputs {
+		m.inputs[i].Reset()
+	}
+
+	for i := range m.toggles {
+		m.toggles[i].selected = 0
+	}
+
+	m.inputs[passwordInputIndex].Placeholder = "Password (optional)"
+
+	return m.focusField(0)
+}
+
+// fillForm fills the connection form with the fields of an existing connection to edit it. The password is left empty, in which case the stored one is kept.
+//
+// It returns a slice of commands to be executed.
+func (m *model) fillForm(conn connection.Connection) []tea.Cmd {
+	cmds := m.resetForm()
+	m.editedConnection = conn
+
+	var forwards []string
+	for _, forward := range conn.Forwards {
+		forwards = append(forwards, forward.String())
+	}
+
+	m.inputs[sshInputIndex].SetValue(conn.Destination())
+	m.inputs[identityFilesInputIndex].SetValue(strings.Join(conn.IdentityFiles, ", "))
+	m.inputs[aliasInputIndex].SetValue(conn.Alias)
+	m.inputs[groupInputIndex].SetValue(conn.Group)
+	m.inputs[tagsInputIndex].SetValue(strings.Join(conn.Tags, ", "))
+	m.inputs[jumpHostsInputIndex].SetValue(strings.Join(m.manager.JumpHostNames(conn), ", "))
+	m.inputs[forwardsInputIndex].SetValue(strings.Join(forwards, ", "))
+
+	if conn.IsPassword {
+		m.inputs[passwordInputIndex].Placeholder = "Password (leave empty to keep the stored one)"
+	}
+
+	m.toggles[identitiesOnlyToggleIndex].setChecked(conn.IdentitiesOnly)
+	m.toggles[keySourceToggleIndex].selectOption(string(conn.KeySource))
+	m.toggles[reconnectToggleIndex].setChecked(conn.Reconnect)
+	m.toggles[forwardAgentToggleIndex].setChecked(conn.ForwardAgent)
+
+	return cmds
+}
+
+// toggleCount returns the number of toggles shown in the form, as some are only shown when editing a connection.
+func (m model) toggleCount() int {
+	if m.currentPage == editConnection || (m.currentPage == unlockVault && m.previousPage == editConnection) {
+		return len(m.toggles)
+	}
+
+	return removePasswordToggleIndex
+}
+
+// handleInputNavigation handles the navigation between the text inputs, the toggles and the button.
 //
 // It returns a slice of commands to be executed.
 func (m *model) handleInputNavigation(key string) []tea.Cmd {
-	var cmds []tea.Cmd
 	// adding one to account for the button
+	fieldCount := len(m.inputs) + m.toggleCount() + 1
 	if key == "tab" || key == "down" {
-		m.focusedInputIndex = (m.focusedInputIndex + 1) % (len(m.inputs) + 1)
-	} else {
-		m.focusedInputIndex = (m.focusedInputIndex - 1 + (len(m.inputs)) + 1) % (len(m.inputs) + 1)
+		return m.focusField((m.focusedInputIndex + 1) % fieldCount)
 	}
 
+	return m.focusField((m.focusedInputIndex - 1 + fieldCount) % fieldCount)
+}
+
+// focusField focuses the form field at index, which can be a text input, a toggle, or the button.
+//
+// It returns a slice of commands to be executed.
+func (m *model) focusField(index int) []tea.Cmd {
+	var cmds []tea.Cmd
+	m.focusedInputIndex = index
+
 	for i := range m.inputs {
 		if i == m.focusedInputIndex {
 			cmds = append(cmds, m.inputs[i].Focus())
@@ -279,6 +1057,10 @@ func (m *model) handleInputNavigation(key string) []tea.Cmd {
 			m.inputs[i].TextStyle = blurredStyle
 		}
 	}
+
+	for i := range m.toggles {
+		m.toggles[i].focused = len(m.inputs)+i == m.focusedInputIndex
+	}
 	return cmds
 }
 
@@ -0,0 +1,54 @@
+package ui
+
+import (
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// reachabilityCheckedMsg is sent once a connection has been checked.
+type reachabilityCheckedMsg struct {
+	id           string
+	reachability connection.Reachability
+}
+
+// reachabilityTickMsg is sent periodically to check the connections again.
+type reachabilityTickMsg struct{}
+
+// checkReachability checks all the connections in the background, unless a check is still running.
+//
+// It returns a command to be executed.
+func (m *model) checkReachability() tea.Cmd {
+	if m.checker == nil || m.pendingChecks > 0 {
+		return nil
+	}
+
+	cmds := []tea.Cmd{}
+	for _, conn := range m.manager.Connections {
+		previous := m.reachability[conn.ID]
+		previous.Status = connection.ReachabilityChecking
+		m.reachability[conn.ID] = previous
+
+		manager, checker := m.manager, m.checker
+		cmds = append(cmds, func() tea.Msg {
+			return reachabilityCheckedMsg{id: conn.ID, reachability: checker.Check(manager, conn)}
+		})
+	}
+
+	m.pendingChecks = len(cmds)
+
+	return tea.Batch(append(cmds, m.setItems())...)
+}
+
+// scheduleReachabilityCheck returns a command sending a reachabilityTickMsg once the check interval has elapsed, or nil if periodic checks are disabled.
+func (m model) scheduleReachabilityCheck() tea.Cmd {
+	interval, err := m.manager.Checks.CheckInterval()
+	if err != nil || interval <= 0 {
+		return nil
+	}
+
+	return tea.Tick(interval, func(time.Time) tea.Msg {
+		return reachabilityTickMsg{}
+	})
+}
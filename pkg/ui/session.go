@@ -0,0 +1,59 @@
+package ui
+
+import (
+	"io"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nezia1/ssh-manager/pkg/connection"
+)
+
+// sessionEndedMsg is sent once an interactive session started from the list is over.
+type sessionEndedMsg struct {
+	result connection.SessionResult
+	err    error
+}
+
+// sessionCommand runs an interactive session with the built-in SSH client while the program is suspended. It implements tea.ExecCommand.
+type sessionCommand struct {
+	manager connection.ConnectionManager
+	conn    connection.Connection
+	result  connection.SessionResult
+}
+
+// Run starts the session. The terminal is released during the session, so prompts such as host key confirmations or the vault passphrase can be answered on it.
+func (sc *sessionCommand) Run() error {
+	var err error
+	sc.result, err = sc.manager.StartSession(sc.conn)
+
+	return err
+}
+
+// the session always runs on the terminal of the process, which is the one the program uses
+func (sc *sessionCommand) SetStdin(io.Reader)  {}
+func (sc *sessionCommand) SetStdout(io.Writer) {}
+func (sc *sessionCommand) SetStderr(io.Writer) {}
+
+// startSession suspends the program to start an interactive session with conn, and resumes it once the session is over.
+//
+// It returns a command sending a sessionEndedMsg.
+func (m model) startSession(conn connection.Connection) tea.Cmd {
+	session := &sessionCommand{manager: m.manager, conn: conn}
+
+	return tea.Exec(session, func(err error) tea.Msg {
+		return sessionEndedMsg{result: session.result, err: err}
+	})
+}
+
+// status describes how a session ended, along with the traffic of its forwards.
+func (msg sessionEndedMsg) status() string {
+	if msg.err != nil {
+		return msg.result.Conn.Name() + ": " + msg.err.Error()
+	}
+
+	status := msg.result.String()
+	for _, stat := range msg.result.Forwards {
+		status += " · " + stat.String()
+	}
+
+	return status
+}
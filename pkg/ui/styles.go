@@ -6,6 +6,7 @@ var (
 	appStyle     = lipgloss.NewStyle().Padding(1, 2)
 	focusedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("5"))
 	blurredStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("12"))
+	errorStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
 	popupStyle   = lipgloss.NewStyle().
 			Foreground(lipgloss.Color("15")).
 			Border(lipgloss.RoundedBorder()).
@@ -17,4 +18,8 @@ var (
 			Padding(0, 3).
 			MarginTop(1)
 	focusedButtonStyle = buttonStyle.Background(lipgloss.Color("5"))
+	filePaneStyle      = lipgloss.NewStyle().
+				Border(lipgloss.RoundedBorder()).
+				BorderForeground(lipgloss.Color("12"))
+	activeFilePaneStyle = filePaneStyle.BorderForeground(lipgloss.Color("5"))
 )
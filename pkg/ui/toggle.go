@@ -0,0 +1,69 @@
+package ui
+
+import (
+	"fmt"
+	"slices"
+)
+
+// toggle is a form field switched with space when focused. It is either a checkbox, or cycles through a list of options.
+type toggle struct {
+	label    string
+	options  []string
+	selected int
+	focused  bool
+}
+
+// newToggle returns a checkbox toggle.
+func newToggle(label string) toggle {
+	return toggle{label: label}
+}
+
+// newChoice returns a toggle cycling through the given options, the first one being selected.
+func newChoice(label string, options ...string) toggle {
+	return toggle{label: label, options: options}
+}
+
+// next switches the checkbox, or selects the next option.
+func (t *toggle) next() {
+	optionCount := max(len(t.options), 2)
+	t.selected = (t.selected + 1) % optionCount
+}
+
+// setChecked checks or unchecks the checkbox.
+func (t *toggle) setChecked(checked bool) {
+	t.selected = 0
+	if checked {
+		t.selected = 1
+	}
+}
+
+// selectOption selects the given option, or the first one if it does not exist.
+func (t *toggle) selectOption(option string) {
+	t.selected = max(slices.Index(t.options, option), 0)
+}
+
+func (t toggle) checked() bool {
+	return t.selected == 1
+}
+
+func (t toggle) value() string {
+	return t.options[t.selected]
+}
+
+func (t toggle) View() string {
+	var view string
+	switch {
+	case t.options != nil:
+		view = fmt.Sprintf("%s: < %s >", t.label, t.value())
+	case t.checked():
+		view = "[x] " + t.label
+	default:
+		view = "[ ] " + t.label
+	}
+
+	if t.focused {
+		return focusedStyle.Render(view)
+	}
+
+	return blurredStyle.Render(view)
+}
@@ -4,19 +4,16 @@ import (
 	"log"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/nezia1/ssh-manager/pkg/connection"
 )
 
 func Start() {
+	// the vault cannot be unlocked on the terminal while the TUI is running, it has its own page instead. Sessions dial with their own prompt once the terminal is released, so this is never changed while the TUI runs.
+	connection.VaultPassphrasePrompt = nil
+
 	p := tea.NewProgram(initialModel(), tea.WithAltScreen())
-	m, err := p.Run()
+	_, err := p.Run()
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	if m.(model).selectedConnection != nil {
-		err := m.(model).selectedConnection.StartSession()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
 }
@@ -1,17 +1,36 @@
 package ui
 
 import (
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/nezia1/ssh-manager/pkg/connection"
 )
 
 func (m model) View() string {
+	if m.err != nil {
+		return renderError(m)
+	}
+
 	switch m.currentPage {
 	case home:
 		return renderHome(m)
-	case addConnection:
+	case addConnection, editConnection:
 		return renderAddConnection(m)
+	case confirmHostKey:
+		return renderConfirmHostKey(m)
+	case unlockVault:
+		return renderUnlockVault(m)
+	case runCommand:
+		return renderRunCommand(m)
+	case execResults:
+		return renderExecResults(m)
+	case browseFiles:
+		return renderFileBrowser(m)
+	case watchForwards:
+		return renderForwards(m)
 	}
 	return ""
 }
@@ -23,18 +42,37 @@ func renderHome(m model) string {
 func renderAddConnection(m model) string {
 	var b strings.Builder
 	var button string
-	// Render the text inputs
+	// Render the text inputs and toggles
 	for i := range m.inputs {
 		b.WriteString(m.inputs[i].View())
-		if i < len(m.inputs)-1 {
+		b.WriteRune('\n')
+
+		if i < len(m.inputErrors) && m.inputErrors[i] != "" {
+			b.WriteString(errorStyle.Render(m.inputErrors[i]))
 			b.WriteRune('\n')
 		}
 	}
+	for i := range m.toggleCount() {
+		b.WriteString(m.toggles[i].View())
+		if i < m.toggleCount()-1 {
+			b.WriteRune('\n')
+		}
+	}
+
+	label := "Add connection"
+	if m.currentPage == editConnection {
+		label = "Save connection"
+	}
+
 	// TODO: is a button really necessary?
-	if m.focusedInputIndex != len(m.inputs) {
-		button = buttonStyle.Render("Add connection")
+	if m.focusedInputIndex != len(m.inputs)+m.toggleCount() {
+		button = buttonStyle.Render(label)
 	} else {
-		button = focusedButtonStyle.Render("Add connection")
+		button = focusedButtonStyle.Render(label)
+	}
+
+	if m.formErr != "" {
+		b.WriteString("\n\n" + errorStyle.Render(m.formErr))
 	}
 
 	popupContent := lipgloss.JoinVertical(lipgloss.Top, b.String(), button)
@@ -42,3 +80,271 @@ func renderAddConnection(m model) string {
 		popupStyle.Render(popupContent))
 
 }
+
+func renderConfirmHostKey(m model) string {
+	var b strings.Builder
+	key := m.pendingHostKey.Key
+
+	fmt.Fprintf(&b, "The authenticity of host '%s' can't be established.\n\n", m.pendingHostKey.Conn.Address())
+	fmt.Fprintf(&b, "%s key fingerprint is\n%s\n\n", key.Type(), focusedStyle.Render(connection.Fingerprint(key)))
+	b.WriteString("Trust this host and connect? (y/n)")
+
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center,
+		popupStyle.Render(b.String()))
+}
+
+func renderUnlockVault(m model) string {
+	var b strings.Builder
+
+	vault, err := connection.OpenVault()
+	switch {
+	case err == nil && !vault.Exists() && m.newPassphrase == "":
+		b.WriteString("Choose a master passphrase for the new vault\n\n")
+	case err == nil && !vault.Exists():
+		b.WriteString("Confirm the master passphrase\n\n")
+	default:
+		b.WriteString("Enter the vault passphrase\n\n")
+	}
+
+	b.WriteString(m.passphraseInput.View())
+
+	if m.vaultErr != "" {
+		b.WriteString("\n\n" + errorStyle.Render(m.vaultErr))
+	}
+
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center,
+		popupStyle.Render(b.String()))
+}
+
+func renderRunCommand(m model) string {
+	var b strings.Builder
+
+	connections := "connections"
+	if len(m.execTargetConns) == 1 {
+		connections = "connection"
+	}
+
+	fmt.Fprintf(&b, "Run a command on %d %s (%s)\n\n", len(m.execTargetConns), connections, m.execTargetName)
+	b.WriteString(m.commandInput.View() + "\n\n")
+	b.WriteString(blurredStyle.Render("enter run • esc cancel"))
+
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center,
+		popupStyle.Render(b.String()))
+}
+
+func renderForwards(m model) string {
+	var b strings.Builder
+	session := m.forwards
+
+	b.WriteString(fmt.Sprintf("Forwarding through %s · open for %s\n\n", focusedStyle.Render(session.Conn.Name()), time.Since(session.Start).Round(time.Second)))
+
+	width := 0
+	for _, stat := range session.Stats {
+		width = max(width, len(stat.Forward.String()))
+	}
+
+	b.WriteString(blurredStyle.Render(fmt.Sprintf("%-*s  %11s  %10s  %10s", width, "Forward", "Connections", "In", "Out")) + "\n")
+	for _, stat := range session.Stats {
+		b.WriteString(fmt.Sprintf("%-*s  %11d  %10s  %10s\n", width, stat.Forward, stat.Connections.Load(), connection.FormatBytes(stat.BytesIn.Load()), connection.FormatBytes(stat.BytesOut.Load())))
+	}
+
+	b.WriteString("\n" + blurredStyle.Render("esc close forwards"))
+
+	return appStyle.Render(b.String())
+}
+
+func renderExecResults(m model) string {
+	var b strings.Builder
+	run := m.execRun
+
+	title := "Ran " + focusedStyle.Render(run.command)
+	if running := run.running(); running > 0 {
+		title = fmt.Sprintf("Running %s · %d of %d left", focusedStyle.Render(run.command), running, len(run.hosts))
+	}
+	b.WriteString(title + "\n\n")
+
+	// the hosts take at most a third of the page, scrolling with the selection, and the output of the selected one the rest
+	hostLines := min(len(run.hosts), max(m.height/3, 3))
+	first := min(max(run.selected-hostLines/2, 0), len(run.hosts)-hostLines)
+	width := 0
+	for _, host := range run.hosts {
+		width = max(width, len(host.conn.Name()))
+	}
+
+	for i, host := range run.hosts[first : first+hostLines] {
+		line := fmt.Sprintf("%-*s  %s", width, host.conn.Name(), host.status())
+		switch {
+		case first+i == run.selected:
+			line = focusedStyle.Render("> " + line)
+		case host.result != nil && (host.result.Err != nil || host.result.ExitStatus != 0):
+			line = "  " + errorStyle.Render(line)
+		default:
+			line = "  " + line
+		}
+
+		b.WriteString(line + "\n")
+	}
+
+	selected := run.hosts[run.selected]
+	output, _ := selected.output.snapshot()
+	output = strings.TrimRight(strings.ReplaceAll(output, "\r", ""), "\n")
+	if selected.result != nil && selected.result.Err != nil {
+		output = strings.TrimLeft(output+"\n"+errorStyle.Render(selected.result.Err.Error()), "\n")
+	}
+
+	b.WriteString("\n" + blurredStyle.Render("── "+selected.conn.Name()+" ──") + "\n")
+
+	// only the end of the output fits below the hosts
+	outputLines := max(m.height-hostLines-6, 1)
+	lines := strings.Split(lipgloss.NewStyle().Width(m.width).Render(output), "\n")
+	if len(lines) > outputLines {
+		lines = lines[len(lines)-outputLines:]
+	}
+	b.WriteString(strings.Join(lines, "\n") + "\n\n")
+
+	b.WriteString(blurredStyle.Render("↑/↓ select connection • esc back"))
+
+	return appStyle.Render(b.String())
+}
+
+func renderFileBrowser(m model) string {
+	b := m.files
+
+	// the two panes share the width of the page, and leave two lines below them for the status and help
+	paneWidth := max(m.width/2-2, 10)
+	paneHeight := max(m.height-4, 3)
+
+	panes := make([]string, len(b.panes))
+	for i, pane := range b.panes {
+		style := filePaneStyle
+		if i == b.active {
+			style = activeFilePaneStyle
+		}
+
+		panes[i] = style.Width(paneWidth).Height(paneHeight).Render(renderFilePane(pane, i == b.active, paneWidth, paneHeight))
+	}
+
+	var status string
+	switch {
+	case b.confirm != "":
+		status = focusedStyle.Render(b.confirm)
+	case b.action != noFileAction:
+		status = b.input.View()
+		if b.err != "" {
+			status += "  " + errorStyle.Render(b.err)
+		}
+	case b.err != "":
+		status = errorStyle.Render(b.err)
+	case b.transfer != nil:
+		t := b.transfer
+		copied := t.copied.Load()
+		percent := 1.0
+		if t.size > 0 {
+			percent = float64(copied) / float64(t.size)
+		}
+
+		label := fmt.Sprintf("%s %s/%s ", truncateLeft(t.name, m.width/3), connection.FormatBytes(copied), connection.FormatBytes(t.size))
+		bar := b.progress
+		bar.Width = max(m.width-lipgloss.Width(label), 10)
+		status = label + bar.ViewAs(min(percent, 1))
+	default:
+		status = b.status
+	}
+
+	help := blurredStyle.Render("tab switch side • enter open • ← parent • c copy • r rename • n new directory • x delete • esc close")
+
+	return appStyle.Render(lipgloss.JoinVertical(lipgloss.Left,
+		lipgloss.JoinHorizontal(lipgloss.Top, panes...),
+		status,
+		help,
+	))
+}
+
+// renderFilePane renders the directory listed by the pane, scrolled so that the selected entry is visible.
+func renderFilePane(pane *filePane, active bool, width, height int) string {
+	var b strings.Builder
+
+	b.WriteString(focusedStyle.Render(pane.name) + " " + truncateLeft(pane.dir, width-lipgloss.Width(pane.name)-1) + "\n")
+
+	if pane.dir == "" {
+		b.WriteString(blurredStyle.Render("loading…"))
+		return b.String()
+	}
+
+	if len(pane.entries) == 0 {
+		b.WriteString(blurredStyle.Render("empty directory"))
+		return b.String()
+	}
+
+	rows := height - 1
+	first := min(max(pane.cursor-rows/2, 0), max(len(pane.entries)-rows, 0))
+	last := min(first+rows, len(pane.entries))
+
+	for i, entry := range pane.entries[first:last] {
+		name, size := entry.Name(), ""
+		if entry.IsDir() {
+			name += "/"
+		} else {
+			size = connection.FormatBytes(entry.Size())
+		}
+
+		// the name is cut so that the size fits on the same line
+		nameWidth := max(width-len(size)-3, 1)
+		if len([]rune(name)) > nameWidth {
+			name = string([]rune(name)[:nameWidth-1]) + "…"
+		}
+		line := fmt.Sprintf("%-*s %s", nameWidth, name, size)
+
+		switch {
+		case first+i == pane.cursor && active:
+			line = focusedStyle.Render("> " + line)
+		case first+i == pane.cursor:
+			line = "> " + line
+		case entry.IsDir():
+			line = "  " + blurredStyle.Render(line)
+		default:
+			line = "  " + line
+		}
+
+		b.WriteString(line)
+		if first+i < last-1 {
+			b.WriteRune('\n')
+		}
+	}
+
+	return b.String()
+}
+
+// truncateLeft cuts the beginning of s so that it fits in width columns, which keeps the end of paths visible.
+func truncateLeft(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width || width < 1 {
+		return s
+	}
+
+	return "…" + string(runes[len(runes)-width+1:])
+}
+
+func renderError(m model) string {
+	var b strings.Builder
+
+	b.WriteString(errorStyle.Render("Error") + "\n\n")
+	b.WriteString(m.err.err.Error() + "\n\n")
+
+	var actions []string
+	if m.err.retry != nil {
+		actions = append(actions, "r retry")
+	}
+	if m.err.modified {
+		actions = append(actions, "m merge my changes", "l reload and discard them")
+	}
+	if !m.err.required {
+		actions = append(actions, "esc dismiss")
+	}
+	actions = append(actions, "q quit")
+
+	b.WriteString(blurredStyle.Render(strings.Join(actions, " • ")))
+
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center,
+		popupStyle.Width(min(m.width, 80)).Render(b.String()))
+}